@@ -0,0 +1,110 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// nodeCache memoizes the nodes of the provider lookups by config string.
+type nodeCache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+
+	// now returns the current time. If nil, time.Now is used.
+	now func() time.Time
+}
+
+// cacheEntry is a cached provider lookup.
+type cacheEntry struct {
+	nodes   []Node
+	expires time.Time
+}
+
+func (c *nodeCache) time() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// get returns the cached nodes for key. If fresh is set expired entries are
+// ignored.
+func (c *nodeCache) get(key string, fresh bool) ([]Node, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || (fresh && !c.time().Before(e.expires)) {
+		return nil, false
+	}
+	return copyNodes(e.nodes), true
+}
+
+// set caches the nodes for key for ttl.
+func (c *nodeCache) set(key string, nodes []Node, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = map[string]cacheEntry{}
+	}
+	c.entries[key] = cacheEntry{nodes: copyNodes(nodes), expires: c.time().Add(ttl)}
+}
+
+// copyNodes returns a copy of nodes so that callers cannot modify the cache.
+// The Meta maps are shared since they are never modified.
+func copyNodes(nodes []Node) []Node {
+	if nodes == nil {
+		return nil
+	}
+	return append([]Node(nil), nodes...)
+}
+
+// cacheTTL returns the time to cache the lookup for args from the cache
+// option or Discover.CacheTTL. Zero disables caching.
+func (d *Discover) cacheTTL(args Config) (time.Duration, error) {
+	v := args["cache"]
+	if v == "" {
+		return d.CacheTTL, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("discover: invalid cache %q", v)
+	}
+	return ttl, nil
+}
+
+// cachedNodes looks up the nodes with the provider like retryNodes but
+// returns the cached nodes for the same config while they have not expired.
+// If the lookup fails the last cached nodes are returned regardless of
+// their age.
+func (d *Discover) cachedNodes(ctx context.Context, p Provider, args Config, l *log.Logger) ([]Node, error) {
+	ttl, err := d.cacheTTL(args)
+	if err != nil {
+		return nil, err
+	}
+	if ttl == 0 {
+		return d.retryNodes(ctx, p, args, l)
+	}
+
+	key := args.String()
+	if nodes, ok := d.cache.get(key, true); ok {
+		l.Printf("[DEBUG] discover: Using cached nodes of provider %q", args["provider"])
+		return nodes, nil
+	}
+
+	nodes, err := d.retryNodes(ctx, p, args, l)
+	if err != nil {
+		if stale, ok := d.cache.get(key, false); ok {
+			l.Printf("[WARN] discover: Using cached nodes of provider %q after error: %s", args["provider"], err)
+			return stale, nil
+		}
+		return nil, err
+	}
+
+	d.cache.set(key, nodes, ttl)
+	return nodes, nil
+}
@@ -0,0 +1,136 @@
+package discover
+
+import (
+	"errors"
+	"log"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyProvider counts its calls and fails while err is set.
+type flakyProvider struct {
+	mu    sync.Mutex
+	calls int
+	addrs []string
+	err   error
+}
+
+func (p *flakyProvider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.addrs, nil
+}
+
+func (p *flakyProvider) Help() string { return "" }
+
+func (p *flakyProvider) set(addrs []string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.addrs, p.err = addrs, err
+}
+
+func (p *flakyProvider) callCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func TestCache(t *testing.T) {
+	p := &flakyProvider{addrs: []string{"10.0.0.1"}}
+	d := Discover{Providers: map[string]Provider{"flaky": p}}
+	now := time.Unix(0, 0)
+	d.cache.now = func() time.Time { return now }
+
+	lookup := func(cfg string, want ...string) {
+		t.Helper()
+		addrs, err := d.Addrs(cfg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(addrs, want) {
+			t.Fatalf("got %v want %v", addrs, want)
+		}
+	}
+
+	lookup("provider=flaky cache=30s", "10.0.0.1")
+	p.set([]string{"10.0.0.2"}, nil)
+
+	// the normalized config shares the cache entry
+	now = now.Add(29 * time.Second)
+	lookup("cache=30s   provider=flaky", "10.0.0.1")
+	if got := p.callCount(); got != 1 {
+		t.Fatalf("got %d calls want 1", got)
+	}
+
+	// expired
+	now = now.Add(time.Second)
+	lookup("provider=flaky cache=30s", "10.0.0.2")
+
+	// stale results are returned on errors
+	p.set(nil, errors.New("rate limited"))
+	now = now.Add(time.Hour)
+	lookup("provider=flaky cache=30s", "10.0.0.2")
+
+	// without a cached result the error is returned
+	if _, err := d.Addrs("provider=flaky cache=1m", nil); err == nil {
+		t.Fatal("expected error")
+	}
+	// no caching by default
+	if _, err := d.Addrs("provider=flaky", nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if got := p.callCount(); got != 5 {
+		t.Fatalf("got %d calls want 5", got)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	p := &flakyProvider{addrs: []string{"10.0.0.1"}}
+	d, err := New(WithProviders(map[string]Provider{"flaky": p}), WithCacheTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := d.Addrs("provider=flaky", nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// concurrent lookups may miss the cache at the same time but later ones
+	// do not call the provider again
+	calls := p.callCount()
+	if _, err := d.Addrs("provider=flaky", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.callCount(); got != calls {
+		t.Fatalf("got %d calls want %d", got, calls)
+	}
+
+	// the cache option overrides the default
+	if _, err := d.Addrs("provider=flaky cache=0s", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.callCount(); got != calls+1 {
+		t.Fatalf("got %d calls want %d", got, calls+1)
+	}
+
+	if _, err := d.Addrs("provider=flaky cache=soon", nil); err == nil {
+		t.Fatal("expected error for invalid cache")
+	}
+	if _, err := New(WithCacheTTL(-time.Second)); err == nil {
+		t.Fatal("expected error for negative TTL")
+	}
+}
@@ -2,15 +2,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"text/template"
+	"time"
 
 	discover "github.com/hashicorp/go-discover"
+	"github.com/hashicorp/go-discover/provider/exec"
 )
 
 func main() {
@@ -20,15 +26,23 @@ func main() {
 	flag.BoolVar(&help, "h", false, "print help")
 	flag.Parse()
 
-	d := &discover.Discover{}
+	// the exec provider runs arbitrary commands and is therefore only
+	// registered for the command line tool
+	providers := map[string]discover.Provider{"exec": &exec.Provider{}}
+	for name, p := range discover.Providers {
+		providers[name] = p
+	}
+	d := &discover.Discover{Providers: providers}
 
 	args := flag.Args()
-	if help || len(args) == 0 || args[0] != "addrs" {
-		fmt.Println("Usage: discover addrs key=val key=val ...")
+	if help || len(args) == 0 || (args[0] != "addrs" && args[0] != "watch" && args[0] != "validate") {
+		fmt.Println("Usage: discover addrs [-format=text|json|go-template=TEMPLATE] key=val key=val ...")
+		fmt.Println("       discover watch [-interval=30s] key=val key=val ...")
+		fmt.Println("       discover validate [-timeout=30s] key=val key=val ... [+ key=val ...]")
 		fmt.Println(d.Help())
 		os.Exit(0)
 	}
-	args = args[1:]
+	cmd, args := args[0], args[1:]
 
 	var w io.Writer = os.Stderr
 	if quiet {
@@ -38,9 +52,113 @@ func main() {
 
 	l.Printf("Registered providers: %v", d.Names())
 
-	addrs, err := d.Addrs(strings.Join(args, " "), l)
+	switch cmd {
+	case "watch":
+		watch(d, args, l)
+		return
+	case "validate":
+		validate(d, args, l)
+		return
+	}
+
+	addrs(d, args, l)
+}
+
+// addrs prints the discovered addresses in the format selected with the
+// -format flag:
+//
+//	text                  the addresses separated by spaces (default)
+//	json                  a JSON array of the nodes with their metadata
+//	go-template=TEMPLATE  the output of the Go template for the nodes
+func addrs(d *discover.Discover, args []string, l *log.Logger) {
+	fs := flag.NewFlagSet("addrs", flag.ExitOnError)
+	format := fs.String("format", "text", "output format: text, json or go-template=TEMPLATE")
+	fs.Parse(args)
+
+	var tmpl *template.Template
+	switch {
+	case *format == "text", *format == "json":
+	case strings.HasPrefix(*format, "go-template="):
+		t, err := template.New("format").Parse(strings.TrimPrefix(*format, "go-template="))
+		if err != nil {
+			l.Fatalf("invalid template: %s", err)
+		}
+		tmpl = t
+	default:
+		l.Fatalf("invalid format %q", *format)
+	}
+
+	nodes, err := d.Nodes(strings.Join(fs.Args(), " "), l)
+	if err != nil {
+		l.Fatal(err)
+	}
+	if nodes == nil {
+		nodes = []discover.Node{}
+	}
+
+	switch {
+	case tmpl != nil:
+		if err := tmpl.Execute(os.Stdout, nodes); err != nil {
+			l.Fatal(err)
+		}
+	case *format == "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(nodes); err != nil {
+			l.Fatal(err)
+		}
+	default:
+		var addrs []string
+		for _, n := range nodes {
+			addrs = append(addrs, n.Addr)
+		}
+		fmt.Println(strings.Join(addrs, " "))
+	}
+}
+
+// watch prints a line "+ addr" for every added and "- addr" for every
+// removed address until interrupted.
+func watch(d *discover.Discover, args []string, l *log.Logger) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := fs.Duration("interval", 30*time.Second, "time between lookups")
+	fs.Parse(args)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	events, err := d.Watch(ctx, strings.Join(fs.Args(), " "), *interval, l)
 	if err != nil {
 		l.Fatal(err)
 	}
-	fmt.Println(strings.Join(addrs, " "))
+	for e := range events {
+		sign := "+"
+		if e.Type == discover.EventRemove {
+			sign = "-"
+		}
+		fmt.Println(sign, e.Node.Addr)
+	}
+}
+
+// validate checks the options and credentials of the providers without
+// listing the nodes if the provider supports it and exits with a non-zero
+// status if any of them fails.
+func validate(d *discover.Discover, args []string, l *log.Logger) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 30*time.Second, "time to wait for the providers")
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if err := d.PingContext(ctx, strings.Join(fs.Args(), " "), l); err != nil {
+		l.Fatal(err)
+	}
+	fmt.Println("ok")
 }
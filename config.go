@@ -1,6 +1,8 @@
 package discover
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strconv"
@@ -12,9 +14,10 @@ import (
 type Config map[string]string
 
 // Parse parses a "key=val key=val ..." string into a config map. Keys
-// and values which contain spaces, backslashes or double-quotes must be
-// quoted with double quotes. Use the backslash to escape special
-// characters within quoted strings, e.g. "some key"="some \"value\"".
+// and values which contain spaces, equals signs, backslashes or
+// double-quotes must be quoted with double quotes. Use the backslash to
+// escape special characters within quoted strings, e.g.
+// "some key"="some \"value\"".
 func Parse(s string) (Config, error) {
 	return parse(s)
 }
@@ -33,7 +36,7 @@ func (c Config) String() string {
 	keys = append([]string{"provider"}, keys...)
 
 	quote := func(s string) string {
-		if strings.ContainsAny(s, ` "\`) {
+		if strings.ContainsAny(s, ` "\=`) {
 			return strconv.Quote(s)
 		}
 		return s
@@ -50,6 +53,56 @@ func (c Config) String() string {
 	return strings.Join(vals, " ")
 }
 
+// ParseJSON parses a JSON object or an array of JSON objects with one
+// provider config each, e.g. [{"provider": "aws", ...}, {"provider":
+// "hcloud", ...}]. Number and boolean values are converted to strings.
+func ParseJSON(data []byte) ([]Config, error) {
+	var raw []map[string]interface{}
+	if b := bytes.TrimSpace(data); len(b) > 0 && b[0] == '[' {
+		if err := decodeJSON(b, &raw); err != nil {
+			return nil, err
+		}
+	} else {
+		var m map[string]interface{}
+		if err := decodeJSON(b, &m); err != nil {
+			return nil, err
+		}
+		raw = append(raw, m)
+	}
+
+	var cfgs []Config
+	for _, m := range raw {
+		if len(m) == 0 {
+			return nil, fmt.Errorf("empty provider config")
+		}
+		c := Config{}
+		for k, v := range m {
+			switch v := v.(type) {
+			case string:
+				c[k] = v
+			case json.Number:
+				c[k] = v.String()
+			case bool:
+				c[k] = strconv.FormatBool(v)
+			default:
+				return nil, fmt.Errorf("%s: value must be a string, number or boolean", k)
+			}
+		}
+		cfgs = append(cfgs, c)
+	}
+	return cfgs, nil
+}
+
+// decodeJSON decodes data into v keeping numbers as json.Number.
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON config: %s", err)
+	}
+	return nil
+}
+
 func parse(in string) (Config, error) {
 	m := Config{}
 	s := []rune(strings.TrimSpace(in))
@@ -227,3 +280,49 @@ func lex(s []rune) (itemType, string, int) {
 		return itemText, strings.TrimSpace(string(s)), len(s)
 	}
 }
+
+// parseUnion parses a config string which may contain several provider
+// configurations joined by a standalone '+', e.g.
+// "provider=aws ... + provider=hcloud ...", into one config per provider.
+func parseUnion(s string) ([]Config, error) {
+	var cfgs []Config
+	parts := splitUnion(s)
+	for _, part := range parts {
+		c, err := Parse(part)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil && len(parts) > 1 {
+			return nil, fmt.Errorf("empty provider config")
+		}
+		cfgs = append(cfgs, c)
+	}
+	return cfgs, nil
+}
+
+// splitUnion splits s at every '+' which is surrounded by spaces and not
+// part of a quoted string.
+func splitUnion(s string) []string {
+	r := []rune(s)
+	var parts []string
+	var quoted, escaped bool
+	start := 0
+	for i, c := range r {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == '+':
+			before := i == 0 || r[i-1] == ' '
+			after := i == len(r)-1 || r[i+1] == ' '
+			if before && after {
+				parts = append(parts, string(r[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, string(r[start:]))
+}
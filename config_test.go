@@ -21,6 +21,7 @@ func TestConfigParse(t *testing.T) {
 		{` key = a   key2 = b `, Config{"key": "a", "key2": "b"}, nil},
 		{`  "k e \\\" y" = "a \" b" key2=c`, Config{`k e \" y`: `a " b`, "key2": "c"}, nil},
 		{`secret_access_key="fpOfcHQJAQBczjAxiVpeyLmX1M0M0KPBST+GU2GvEN4="`, Config{"secret_access_key": "fpOfcHQJAQBczjAxiVpeyLmX1M0M0KPBST+GU2GvEN4="}, nil},
+		{`label_selector="env=prod,role in (server,bootstrap)"`, Config{"label_selector": "env=prod,role in (server,bootstrap)"}, nil},
 
 		{`provider=aws foo`, nil, errors.New(`foo: missing '='`)},
 		{`project_name=Test zone_pattern=us-(?west|east).+ tag_value="consul server" credentials_file=xxx`,
@@ -64,6 +65,7 @@ func TestConfigString(t *testing.T) {
 		{`   `, ``},
 		{`b=c "a a"="b b"`, `"a a"="b b" b=c`},
 		{`a=b provider=foo x=y`, `provider=foo a=b x=y`},
+		{`provider=hcloud label_selector="env=prod"`, `provider=hcloud label_selector="env=prod"`},
 	}
 
 	for _, tt := range tests {
@@ -78,3 +80,62 @@ func TestConfigString(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigParseUnion(t *testing.T) {
+	tests := []struct {
+		s   string
+		c   []Config
+		err error
+	}{
+		{`provider=a`, []Config{{"provider": "a"}}, nil},
+		{`provider=a + provider=b x=y`, []Config{{"provider": "a"}, {"provider": "b", "x": "y"}}, nil},
+		{`provider=a key=a+b`, []Config{{"provider": "a", "key": "a+b"}}, nil},
+		{`provider=a key="a + b"`, []Config{{"provider": "a", "key": "a + b"}}, nil},
+		{`provider=a key="\" + " + provider=b`, []Config{{"provider": "a", "key": `" + `}, {"provider": "b"}}, nil},
+
+		// errors
+		{`provider=a +`, nil, errors.New(`empty provider config`)},
+		{`provider=a + key`, nil, errors.New(`key: missing '='`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			c, err := parseUnion(tt.s)
+			if got, want := err, tt.err; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got error %v want %v", got, want)
+			}
+			if got, want := c, tt.c; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got configs %#v want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	tests := []struct {
+		s   string
+		c   []Config
+		err error
+	}{
+		{`{"provider": "a", "label_selector": "env=prod,role in (server)"}`, []Config{{"provider": "a", "label_selector": "env=prod,role in (server)"}}, nil},
+		{` [{"provider": "a"}, {"provider": "b", "port": 8301, "dedup": false}]`, []Config{{"provider": "a"}, {"provider": "b", "port": "8301", "dedup": "false"}}, nil},
+
+		// errors
+		{`{"provider": "a"`, nil, errors.New(`invalid JSON config: unexpected EOF`)},
+		{`[{"provider": "a"}, {}]`, nil, errors.New(`empty provider config`)},
+		{`{"provider": "a", "tags": ["a"]}`, nil, errors.New(`tags: value must be a string, number or boolean`)},
+		{`{"provider": null}`, nil, errors.New(`provider: value must be a string, number or boolean`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			c, err := ParseJSON([]byte(tt.s))
+			if got, want := err, tt.err; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got error %v want %v", got, want)
+			}
+			if got, want := c, tt.c; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got configs %#v want %#v", got, want)
+			}
+		})
+	}
+}
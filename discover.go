@@ -3,27 +3,38 @@
 package discover
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"reflect"
+	"runtime"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/hashicorp/go-discover/provider"
 	"github.com/hashicorp/go-discover/provider/aliyun"
 	"github.com/hashicorp/go-discover/provider/aws"
 	"github.com/hashicorp/go-discover/provider/azure"
 	"github.com/hashicorp/go-discover/provider/digitalocean"
+	"github.com/hashicorp/go-discover/provider/dns"
 	"github.com/hashicorp/go-discover/provider/gce"
 	"github.com/hashicorp/go-discover/provider/hcloud"
+	"github.com/hashicorp/go-discover/provider/http"
 	"github.com/hashicorp/go-discover/provider/linode"
 	"github.com/hashicorp/go-discover/provider/mdns"
+	"github.com/hashicorp/go-discover/provider/netbox"
+	"github.com/hashicorp/go-discover/provider/nomad"
 	"github.com/hashicorp/go-discover/provider/os"
 	"github.com/hashicorp/go-discover/provider/packet"
+	"github.com/hashicorp/go-discover/provider/proxmox"
 	"github.com/hashicorp/go-discover/provider/scaleway"
 	"github.com/hashicorp/go-discover/provider/softlayer"
 	"github.com/hashicorp/go-discover/provider/tencentcloud"
 	"github.com/hashicorp/go-discover/provider/triton"
 	"github.com/hashicorp/go-discover/provider/vsphere"
+	"github.com/hashicorp/go-multierror"
 )
 
 // Provider has lookup functions for meta data in a
@@ -44,16 +55,59 @@ type ProviderWithUserAgent interface {
 	SetUserAgent(s string)
 }
 
+// ProviderWithContext is a provider whose lookups can be canceled or bounded
+// by a deadline. Not all providers support this.
+type ProviderWithContext interface {
+	// AddrsContext looks up addresses like Addrs and aborts the lookup
+	// when ctx is done.
+	AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error)
+}
+
+// Node is a discovered node together with its metadata.
+type Node = provider.Node
+
+// NodeProvider is a provider which returns the discovered nodes together
+// with their metadata. Not all providers support this. The addresses of
+// the other providers are returned as nodes without metadata.
+type NodeProvider interface {
+	// Nodes looks up nodes in the cloud environment according to the
+	// configuration provided in args and aborts the lookup when ctx is
+	// done.
+	Nodes(ctx context.Context, args map[string]string, l *log.Logger) ([]Node, error)
+}
+
+// ProviderWithRetryable is a provider which classifies its errors into
+// transient ones, which are retried, and fatal ones. Not all providers
+// support this. The errors of the other providers are always retried.
+type ProviderWithRetryable interface {
+	// Retryable reports whether a lookup which failed with err may succeed
+	// when it is retried.
+	Retryable(err error) bool
+}
+
+// ProviderWithPing is a provider that can verify its configuration and
+// credentials without looking up any addresses. Not all providers support
+// this. The other providers are verified with a full lookup.
+type ProviderWithPing interface {
+	// Ping checks that the cloud environment can be reached with the
+	// configuration provided in args.
+	Ping(ctx context.Context, args map[string]string, l *log.Logger) error
+}
+
 // Providers contains all available providers.
 var Providers = map[string]Provider{
 	"aliyun":       &aliyun.Provider{},
 	"aws":          &aws.Provider{},
 	"azure":        &azure.Provider{},
 	"digitalocean": &digitalocean.Provider{},
+	"dns":          &dns.Provider{},
 	"gce":          &gce.Provider{},
 	"hcloud":       &hcloud.Provider{},
+	"http":         &http.Provider{},
 	"linode":       &linode.Provider{},
 	"mdns":         &mdns.Provider{},
+	"netbox":       &netbox.Provider{},
+	"nomad":        &nomad.Provider{},
 	"os":           &os.Provider{},
 	"scaleway":     &scaleway.Provider{},
 	"softlayer":    &softlayer.Provider{},
@@ -61,6 +115,7 @@ var Providers = map[string]Provider{
 	"triton":       &triton.Provider{},
 	"vsphere":      &vsphere.Provider{},
 	"packet":       &packet.Provider{},
+	"proxmox":      &proxmox.Provider{},
 }
 
 // Discover looks up metadata in different cloud environments.
@@ -69,9 +124,45 @@ type Discover struct {
 	// If nil, the default list of providers is used.
 	Providers map[string]Provider
 
+	// LogLevel is the minimum level of the log messages written to the
+	// logger passed to Addrs and friends. Messages below it are dropped
+	// before they reach the logger's writer. The default logs everything.
+	LogLevel LogLevel
+
+	// Concurrency is the maximum number of operations which run at the same
+	// time in any fan-out, e.g. when pinging or querying several providers.
+	// It is passed on to the providers with the context of every lookup,
+	// see provider.ContextConcurrency.
+	// The concurrency option of a config overrides it for the requests of
+	// that provider. If zero, runtime.GOMAXPROCS(0) is used.
+	Concurrency int
+
+	// CacheTTL is the time the nodes of a provider config are cached. The
+	// cache option of a config overrides it. If zero, nothing is cached.
+	CacheTTL time.Duration
+
+	// RetryMax is the number of times a failed lookup is retried. The
+	// retry_max option of a config overrides it. If zero, lookups are not
+	// retried.
+	RetryMax int
+
+	// RetryWaitMin and RetryWaitMax bound the wait time between two
+	// retries which doubles with every retry. The retry_wait_min and
+	// retry_wait_max options of a config override them. If zero, 500ms and
+	// 8s are used.
+	RetryWaitMin time.Duration
+	RetryWaitMax time.Duration
+
+	// cache contains the cached nodes by config.
+	cache nodeCache
+
 	// userAgent is the string to use for requests, when supported.
 	userAgent string
 
+	// logger receives all log messages instead of the *log.Logger passed
+	// to Addrs and friends, if set.
+	logger Logger
+
 	// once is used to initialize the actual list of providers.
 	once sync.Once
 }
@@ -103,6 +194,68 @@ func WithUserAgent(agent string) Option {
 	}
 }
 
+// WithLogLevel allows specifying the minimum level of the log messages.
+func WithLogLevel(level LogLevel) Option {
+	return func(d *Discover) error {
+		d.LogLevel = level
+		return nil
+	}
+}
+
+// WithLogger allows specifying a structured logger. See SetLogger.
+func WithLogger(l Logger) Option {
+	return func(d *Discover) error {
+		d.logger = l
+		return nil
+	}
+}
+
+// SetLogger sets a structured logger like an hclog.Logger which receives all
+// log messages instead of the *log.Logger passed to Addrs and friends. The
+// level of the messages is taken from their "[LEVEL]" tag which is removed.
+func (d *Discover) SetLogger(l Logger) {
+	d.logger = l
+}
+
+// WithCacheTTL allows specifying the time the nodes of a provider config are
+// cached.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(d *Discover) error {
+		if ttl < 0 {
+			return fmt.Errorf("discover: invalid cache TTL %s", ttl)
+		}
+		d.CacheTTL = ttl
+		return nil
+	}
+}
+
+// WithRetry allows specifying how often failed lookups are retried and the
+// bounds of the wait time between two retries. Zero wait times select the
+// defaults.
+func WithRetry(max int, waitMin, waitMax time.Duration) Option {
+	return func(d *Discover) error {
+		if max < 0 || waitMin < 0 || waitMax < 0 {
+			return fmt.Errorf("discover: invalid retry policy")
+		}
+		d.RetryMax = max
+		d.RetryWaitMin = waitMin
+		d.RetryWaitMax = waitMax
+		return nil
+	}
+}
+
+// WithConcurrency allows specifying the maximum number of concurrent
+// operations.
+func WithConcurrency(n int) Option {
+	return func(d *Discover) error {
+		if n < 0 {
+			return fmt.Errorf("discover: invalid concurrency %d", n)
+		}
+		d.Concurrency = n
+		return nil
+	}
+}
+
 // WithProviders allows specifying your own set of providers.
 func WithProviders(m map[string]Provider) Option {
 	return func(d *Discover) error {
@@ -113,10 +266,38 @@ func WithProviders(m map[string]Provider) Option {
 
 // initProviders sets the list of providers to the
 // default list of providers if none are configured.
+// If a user agent is set, the providers which support it are replaced by
+// copies with the user agent so that providers shared with other Discovers,
+// like the default ones, are never modified.
 func (d *Discover) initProviders() {
 	if d.Providers == nil {
 		d.Providers = Providers
 	}
+	if d.userAgent == "" {
+		return
+	}
+
+	providers := make(map[string]Provider, len(d.Providers))
+	for name, p := range d.Providers {
+		providers[name] = withUserAgent(p, d.userAgent)
+	}
+	d.Providers = providers
+}
+
+// withUserAgent returns a copy of p with the user agent set if p supports
+// it. Providers which are not pointers to structs cannot be copied and get
+// the user agent set directly.
+func withUserAgent(p Provider, agent string) Provider {
+	if _, ok := p.(ProviderWithUserAgent); !ok {
+		return p
+	}
+	if v := reflect.ValueOf(p); v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+		c := reflect.New(v.Elem().Type())
+		c.Elem().Set(v.Elem())
+		p = c.Interface().(Provider)
+	}
+	p.(ProviderWithUserAgent).SetUserAgent(agent)
+	return p
 }
 
 // Names returns the names of the configured providers.
@@ -137,7 +318,60 @@ var globalHelp = `The options for discovering ip addresses are provided as a
 
     provider=aws region=eu-west-1 ...
 
-  The options are provider specific and are listed below.
+  The configs of several providers can be joined with " + " to
+  discover the nodes of all of them. An address discovered by
+  more than one provider is only returned once:
+
+    provider=aws region=eu-west-1 ... + provider=hcloud ...
+
+  The options are provider specific and are listed below. The
+  following options are supported for all providers:
+
+    static: A comma separated list of addresses which are always
+            returned in addition to the discovered ones, e.g.
+            "static=10.0.0.1,10.0.0.2". They are appended after the
+            discovered addresses and then go through the port,
+            cidr_filter, exclude_self, dedup, sort and limit options
+            like them, so they can be filtered out or cut off.
+
+    port:   A port to append to every address without one, e.g.
+            "port=8301". IPv6 addresses are enclosed in brackets.
+            Addresses for which the provider returns a port, e.g.
+            from a Kubernetes annotation, keep it.
+
+    cidr_filter: A comma separated list of CIDRs, e.g.
+                 "cidr_filter=10.0.0.0/8". Only the addresses in one
+                 of them are returned. Host names are dropped.
+
+    dedup:  "false" to keep duplicate addresses. By default only the
+            first occurrence of an address is returned. (default: "true")
+
+    sort:   "true" to sort the addresses, IPv4 before IPv6 addresses
+            and host names last. By default the addresses are returned
+            in the order of the provider. (default: "false")
+
+    limit:  The maximum number of addresses to return, e.g.
+            "limit=5". It is applied after sorting. Optional.
+
+    exclude_self: "true" to drop the addresses of the local network
+                  interfaces so that a node does not join itself.
+                  (default: "false")
+
+    concurrency: The maximum number of concurrent API requests of
+                 providers which fetch pages or instance details in
+                 parallel, e.g. "concurrency=8". Optional.
+
+    cache:  The time to cache the discovered addresses for the same
+            config, e.g. "cache=30s". Lookups within that time return
+            the cached addresses without calling the provider. If a
+            lookup fails the last cached addresses are returned.
+
+    retry_max:      The number of times a failed lookup is retried,
+                    e.g. "retry_max=3". (default: 0)
+    retry_wait_min: The wait time before the first retry. It doubles
+                    with every retry. (default: "500ms")
+    retry_wait_max: The maximum wait time between two retries.
+                    (default: "8s")
 `
 
 // Help describes the format of the configuration string for address discovery
@@ -152,17 +386,329 @@ func (d *Discover) Help() string {
 	return strings.Join(h, "\n")
 }
 
+// Result is a discovered address together with the name of the provider
+// which discovered it.
+type Result struct {
+	// Addr is the discovered address.
+	Addr string
+
+	// Provider is the name of the provider which discovered the address
+	// or "static" for the addresses of the static option.
+	Provider string
+}
+
 // Addrs discovers ip addresses of nodes that match the given filter criteria.
 // The config string must have the format 'provider=xxx key=val key=val ...'
 // where the keys and values are provider specific. The values are URL encoded.
+// The configs of several providers can be joined with ' + ' to return the
+// addresses of all of them, e.g. 'provider=aws ... + provider=hcloud ...'.
+// An address discovered by several of them is only returned once.
 func (d *Discover) Addrs(cfg string, l *log.Logger) ([]string, error) {
+	return d.AddrsContext(context.Background(), cfg, l)
+}
+
+// AddrsContext discovers ip addresses like Addrs and aborts the lookup when
+// ctx is done. Providers which do not implement ProviderWithContext are not
+// started once ctx is done but cannot be interrupted.
+func (d *Discover) AddrsContext(ctx context.Context, cfg string, l *log.Logger) ([]string, error) {
+	results, err := d.ResultsContext(ctx, cfg, l)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	for _, r := range results {
+		addrs = append(addrs, r.Addr)
+	}
+	return addrs, nil
+}
+
+// AddrsFromMap discovers ip addresses like Addrs for a single provider
+// config given as a map, e.g. {"provider": "hcloud", "label_selector":
+// "env=prod,role in (server)"}. The values need no quoting.
+func (d *Discover) AddrsFromMap(cfg map[string]string, l *log.Logger) ([]string, error) {
+	nodes, err := d.NodesFromConfigs(context.Background(), []Config{cfg}, l)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Addrs(nodes), nil
+}
+
+// AddrsFromJSON discovers ip addresses like Addrs for a JSON object with a
+// single provider config or an array of them. See ParseJSON.
+func (d *Discover) AddrsFromJSON(data []byte, l *log.Logger) ([]string, error) {
+	cfgs, err := ParseJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("discover: %s", err)
+	}
+	nodes, err := d.NodesFromConfigs(context.Background(), cfgs, l)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Addrs(nodes), nil
+}
+
+// Results discovers ip addresses like Addrs and annotates each address with
+// the name of the provider which discovered it. When the config string joins
+// several providers they are queried concurrently and their results are
+// merged in config order, keeping the first result for every address. If
+// any provider fails the failures of all providers are returned together.
+func (d *Discover) Results(cfg string, l *log.Logger) ([]Result, error) {
+	return d.ResultsContext(context.Background(), cfg, l)
+}
+
+// ResultsContext discovers ip addresses like Results and aborts the lookup
+// when ctx is done. See AddrsContext.
+func (d *Discover) ResultsContext(ctx context.Context, cfg string, l *log.Logger) ([]Result, error) {
+	nodes, err := d.NodesContext(ctx, cfg, l)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	for _, n := range nodes {
+		results = append(results, Result{Addr: n.Addr, Provider: n.Provider})
+	}
+	return results, nil
+}
+
+// Nodes discovers nodes like Results and returns them together with the
+// metadata the provider knows about them, e.g. their name, ID and zone.
+// Providers which do not implement NodeProvider only set the address.
+func (d *Discover) Nodes(cfg string, l *log.Logger) ([]Node, error) {
+	return d.NodesContext(context.Background(), cfg, l)
+}
+
+// NodesContext discovers nodes like Nodes and aborts the lookup when ctx is
+// done. See AddrsContext.
+func (d *Discover) NodesContext(ctx context.Context, cfg string, l *log.Logger) ([]Node, error) {
+	cfgs, err := parseUnion(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("discover: %s", err)
+	}
+	return d.NodesFromConfigs(ctx, cfgs, l)
+}
+
+// NodesFromConfigs discovers nodes like NodesContext for already parsed
+// provider configs, e.g. from ParseJSON. This avoids encoding structured
+// configs into a config string.
+func (d *Discover) NodesFromConfigs(ctx context.Context, cfgs []Config, l *log.Logger) ([]Node, error) {
 	d.once.Do(d.initProviders)
 
-	args, err := Parse(cfg)
+	l = d.stdLogger(l)
+	ctx = provider.WithConcurrency(ctx, d.concurrency())
+
+	nodes := make([][]Node, len(cfgs))
+	errs := make([]error, len(cfgs))
+	d.forEach(len(cfgs), func(i int) {
+		nodes[i], errs[i] = d.nodes(ctx, cfgs[i], l)
+	})
+
+	if err := joinErrors(errs); err != nil {
+		return nil, err
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+
+	var all []Node
+	for _, n := range nodes {
+		all = append(all, n...)
+	}
+	return uniqueNodes(all), nil
+}
+
+// nodes looks up the nodes for a single provider config.
+func (d *Discover) nodes(ctx context.Context, args Config, l *log.Logger) ([]Node, error) {
+	p, err := d.provider(args)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := parseNodeOptions(args)
 	if err != nil {
+		return nil, err
+	}
+	if _, err := provider.Concurrency(args, 0); err != nil {
 		return nil, fmt.Errorf("discover: %s", err)
 	}
+	name := args["provider"]
+	l.Printf("[DEBUG] discover: Using provider %q", name)
+
+	nodes, err := d.cachedNodes(ctx, p, args, l)
+	if err != nil {
+		return nil, err
+	}
+	for i := range nodes {
+		nodes[i].Provider = name
+	}
+
+	if args["static"] != "" {
+		nodes = appendStatic(nodes, args["static"])
+	}
+	return opts.apply(nodes), nil
+}
+
+// appendStatic appends the comma separated static addresses to nodes.
+func appendStatic(nodes []Node, static string) []Node {
+	for _, addr := range strings.Split(static, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			nodes = append(nodes, Node{Addr: addr, Provider: "static"})
+		}
+	}
+	return nodes
+}
+
+// uniqueNodes drops the nodes with duplicate addresses, keeping the first
+// occurrence.
+func uniqueNodes(nodes []Node) []Node {
+	seen := map[string]bool{}
+	var unique []Node
+	for _, n := range nodes {
+		if !seen[n.Addr] {
+			seen[n.Addr] = true
+			unique = append(unique, n)
+		}
+	}
+	return unique
+}
+
+// Ping checks the configuration and credentials of every provider in the
+// config string. See PingContext.
+func (d *Discover) Ping(cfg string, l *log.Logger) error {
+	return d.PingContext(context.Background(), cfg, l)
+}
+
+// PingContext checks the configuration and credentials of every provider in
+// the config string concurrently. The config string has the same format as
+// for Addrs but may join the configs of several providers with ' + ', e.g.
+// 'provider=aws ... + provider=hcloud ...'. The global options like port or
+// cache are validated first. Providers which do not implement
+// ProviderWithPing are checked by performing a full address lookup. The
+// failures of all providers are returned together.
+func (d *Discover) PingContext(ctx context.Context, cfg string, l *log.Logger) error {
+	d.once.Do(d.initProviders)
+
+	l = d.stdLogger(l)
+
+	cfgs, err := parseUnion(cfg)
+	if err != nil {
+		return fmt.Errorf("discover: %s", err)
+	}
 
+	ctx = provider.WithConcurrency(ctx, d.concurrency())
+	errs := make([]error, len(cfgs))
+	d.forEach(len(cfgs), func(i int) {
+		errs[i] = d.ping(ctx, cfgs[i], l)
+	})
+
+	return joinErrors(errs)
+}
+
+// ping checks a single provider config.
+func (d *Discover) ping(ctx context.Context, args Config, l *log.Logger) error {
+	p, err := d.provider(args)
+	if err != nil {
+		return err
+	}
+	if err := d.validateOptions(args); err != nil {
+		return err
+	}
+	l.Printf("[DEBUG] discover: Pinging provider %q", args["provider"])
+
+	if typ, ok := p.(ProviderWithPing); ok {
+		return typ.Ping(ctx, args, l)
+	}
+
+	_, err = providerAddrs(ctx, p, args, l)
+	return err
+}
+
+// validateOptions checks the global options in args which are handled for
+// all providers.
+func (d *Discover) validateOptions(args Config) error {
+	if _, err := parseNodeOptions(args); err != nil {
+		return err
+	}
+	if _, err := provider.Concurrency(args, 0); err != nil {
+		return fmt.Errorf("discover: %s", err)
+	}
+	if _, err := d.cacheTTL(args); err != nil {
+		return err
+	}
+	_, err := d.retryPolicy(args)
+	return err
+}
+
+// providerNodes looks up the nodes with the provider. The addresses of
+// providers which do not implement NodeProvider are returned as nodes
+// without metadata.
+func providerNodes(ctx context.Context, p Provider, args Config, l *log.Logger) ([]Node, error) {
+	if typ, ok := p.(NodeProvider); ok {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return typ.Nodes(ctx, args, l)
+	}
+
+	addrs, err := providerAddrs(ctx, p, args, l)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []Node
+	for _, addr := range addrs {
+		nodes = append(nodes, Node{Addr: addr})
+	}
+	return nodes, nil
+}
+
+// providerAddrs looks up the addresses with the provider and passes ctx on
+// if the provider supports it.
+func providerAddrs(ctx context.Context, p Provider, args Config, l *log.Logger) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if typ, ok := p.(ProviderWithContext); ok {
+		return typ.AddrsContext(ctx, args, l)
+	}
+	return p.Addrs(args, l)
+}
+
+// concurrency returns the maximum number of concurrent operations.
+func (d *Discover) concurrency() int {
+	if d.Concurrency > 0 {
+		return d.Concurrency
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// forEach calls fn for every index from 0 to n-1 with at most
+// d.concurrency() calls running at the same time.
+func (d *Discover) forEach(n int, fn func(i int)) {
+	provider.ForEach(n, d.concurrency(), fn)
+}
+
+// joinErrors returns nil if all errors are nil, the error itself if there
+// is only one and a multierror otherwise.
+func joinErrors(errs []error) error {
+	var result *multierror.Error
+	for _, err := range errs {
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	switch {
+	case result == nil:
+		return nil
+	case len(result.Errors) == 1:
+		return result.Errors[0]
+	default:
+		return result
+	}
+}
+
+// provider returns the provider for the given config.
+func (d *Discover) provider(args Config) (Provider, error) {
 	name := args["provider"]
 	if name == "" {
 		return nil, fmt.Errorf("discover: no provider")
@@ -177,12 +723,5 @@ func (d *Discover) Addrs(cfg string, l *log.Logger) ([]string, error) {
 	if p == nil {
 		return nil, fmt.Errorf("discover: unknown provider " + name)
 	}
-	l.Printf("[DEBUG] discover: Using provider %q", name)
-
-	if typ, ok := p.(ProviderWithUserAgent); ok {
-		typ.SetUserAgent(d.userAgent)
-		return p.Addrs(args, l)
-	}
-
-	return p.Addrs(args, l)
+	return p, nil
 }
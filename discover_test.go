@@ -0,0 +1,467 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-discover/provider"
+)
+
+// testProvider is a provider which returns canned results.
+type testProvider struct {
+	addrs []string
+	err   error
+}
+
+func (p *testProvider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.addrs, p.err
+}
+
+func (p *testProvider) Help() string { return "" }
+
+// testPingProvider is a provider which supports pinging.
+type testPingProvider struct {
+	testProvider
+	pingErr error
+}
+
+func (p *testPingProvider) Ping(ctx context.Context, args map[string]string, l *log.Logger) error {
+	return p.pingErr
+}
+
+// testContextProvider is a provider which blocks until ctx is done.
+type testContextProvider struct {
+	testProvider
+}
+
+func (p *testContextProvider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestAddrsContext(t *testing.T) {
+	d := Discover{
+		Providers: map[string]Provider{
+			"ok":    &testProvider{addrs: []string{"1.2.3.4"}},
+			"block": &testContextProvider{testProvider{addrs: []string{"unused"}}},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := d.AddrsContext(ctx, "provider=ok + provider=block", nil); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v want %v", err, context.DeadlineExceeded)
+	}
+
+	// providers without context support are not started once ctx is done
+	if _, err := d.AddrsContext(ctx, "provider=ok", nil); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v want %v", err, context.DeadlineExceeded)
+	}
+
+	addrs, err := d.AddrsContext(context.Background(), "provider=ok", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.2.3.4"}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v want %v", addrs, want)
+	}
+}
+
+// testNodeProvider is a provider which returns canned nodes.
+type testNodeProvider struct {
+	testProvider
+	nodes []Node
+}
+
+func (p *testNodeProvider) Nodes(ctx context.Context, args map[string]string, l *log.Logger) ([]Node, error) {
+	return p.nodes, nil
+}
+
+func TestNodes(t *testing.T) {
+	d := Discover{
+		Providers: map[string]Provider{
+			"addrs": &testProvider{addrs: []string{"1.2.3.4"}},
+			"nodes": &testNodeProvider{
+				testProvider: testProvider{addrs: []string{"unused"}},
+				nodes:        []Node{{Addr: "5.6.7.8", Name: "node-1", ID: "1", Zone: "zone-a"}},
+			},
+		},
+	}
+
+	nodes, err := d.Nodes("provider=addrs + provider=nodes static=1.2.3.4,9.9.9.9", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Node{
+		{Addr: "1.2.3.4", Provider: "addrs"},
+		{Addr: "5.6.7.8", Name: "node-1", ID: "1", Zone: "zone-a", Provider: "nodes"},
+		{Addr: "9.9.9.9", Provider: "static"},
+	}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Fatalf("got %+v want %+v", nodes, want)
+	}
+
+	addrs, err := d.Addrs("provider=nodes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"5.6.7.8"}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v want %v", addrs, want)
+	}
+}
+
+func TestPingContext(t *testing.T) {
+	d := Discover{
+		Providers: map[string]Provider{
+			"ok":       &testProvider{addrs: []string{"1.2.3.4"}},
+			"bad":      &testProvider{err: errors.New("bad credentials")},
+			"ping":     &testPingProvider{testProvider: testProvider{err: errors.New("unused")}},
+			"pingfail": &testPingProvider{pingErr: errors.New("unreachable")},
+		},
+	}
+
+	tests := []struct {
+		cfg  string
+		errs []string
+	}{
+		{`provider=ok`, nil},
+		{`provider=ping`, nil},
+		{`provider=ok + provider=ping`, nil},
+		{`provider=bad`, []string{"bad credentials"}},
+		{`provider=ok + provider=bad + provider=pingfail`, []string{"bad credentials", "unreachable"}},
+		{`provider=ok + provider=nope`, []string{"unknown provider nope"}},
+		{`provider=ok +`, []string{"empty provider config"}},
+		{`provider=ping port=0`, []string{"invalid port"}},
+		{`provider=ping cache=soon + provider=ok retry_max=-1`, []string{"invalid cache", "invalid retry_max"}},
+		{`provider=ping concurrency=0`, []string{"invalid concurrency"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cfg, func(t *testing.T) {
+			err := d.PingContext(context.Background(), tt.cfg, nil)
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Fatalf("got error %v want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got nil error want %v", tt.errs)
+			}
+			for _, want := range tt.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Fatalf("got error %q want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResults(t *testing.T) {
+	d := Discover{
+		Providers: map[string]Provider{
+			"a":   &testProvider{addrs: []string{"10.0.0.1", "10.0.0.2"}},
+			"b":   &testProvider{addrs: []string{"192.168.0.1"}},
+			"bad": &testProvider{err: errors.New("bad credentials")},
+		},
+	}
+
+	results, err := d.Results(`provider=a + provider=b`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Result{
+		{Addr: "10.0.0.1", Provider: "a"},
+		{Addr: "10.0.0.2", Provider: "a"},
+		{Addr: "192.168.0.1", Provider: "b"},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("got %v want %v", results, want)
+	}
+
+	addrs, err := d.Addrs(`provider=b + provider=a`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := addrs, []string{"192.168.0.1", "10.0.0.1", "10.0.0.2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	results, err = d.Results(`provider=a + provider=b + provider=a`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("got %v want %v", results, want)
+	}
+
+	if _, err := d.Results(`provider=a + provider=bad`, nil); err == nil || err.Error() != "bad credentials" {
+		t.Fatalf("got error %v want bad credentials", err)
+	}
+}
+
+func TestResultsStatic(t *testing.T) {
+	d := Discover{
+		Providers: map[string]Provider{
+			"a":     &testProvider{addrs: []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"}},
+			"empty": &testProvider{},
+		},
+	}
+
+	tests := []struct {
+		cfg     string
+		results []Result
+	}{
+		{
+			`provider=a static=10.0.0.3`,
+			[]Result{{"10.0.0.1", "a"}, {"10.0.0.2", "a"}, {"10.0.0.3", "static"}},
+		},
+		{
+			`provider=a static="10.0.0.2, 10.0.0.4,,"`,
+			[]Result{{"10.0.0.1", "a"}, {"10.0.0.2", "a"}, {"10.0.0.4", "static"}},
+		},
+		{
+			`provider=empty static=10.0.0.3,10.0.0.3`,
+			[]Result{{"10.0.0.3", "static"}},
+		},
+		{
+			`provider=a static=10.0.0.3 limit=2`,
+			[]Result{{"10.0.0.1", "a"}, {"10.0.0.2", "a"}},
+		},
+		{
+			`provider=a static=10.0.0.0 sort=true cidr_filter=10.0.0.0/31`,
+			[]Result{{"10.0.0.0", "static"}, {"10.0.0.1", "a"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cfg, func(t *testing.T) {
+			results, err := d.Results(tt.cfg, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := results, tt.results; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+}
+
+// testArgsProvider returns the label_selector argument as address.
+type testArgsProvider struct{}
+
+func (p *testArgsProvider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return []string{args["label_selector"]}, nil
+}
+
+func (p *testArgsProvider) Help() string { return "" }
+
+func TestAddrsFromMap(t *testing.T) {
+	d := Discover{
+		Providers: map[string]Provider{
+			"addrs": &testProvider{addrs: []string{"1.2.3.4"}},
+			"args":  &testArgsProvider{},
+		},
+	}
+
+	addrs, err := d.AddrsFromMap(map[string]string{"provider": "args", "label_selector": `env=prod,role in ("server")`}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`env=prod,role in ("server")`}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v want %v", addrs, want)
+	}
+
+	if _, err := d.AddrsFromMap(map[string]string{"provider": "nope"}, nil); err == nil {
+		t.Fatal("expected error")
+	}
+
+	addrs, err = d.AddrsFromJSON([]byte(`[{"provider": "args", "label_selector": "env=prod"}, {"provider": "addrs", "port": 8301}]`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"env=prod", "1.2.3.4:8301"}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v want %v", addrs, want)
+	}
+
+	if _, err := d.AddrsFromJSON([]byte(`{"provider": ["args"]}`), nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestResultsPort(t *testing.T) {
+	d := Discover{
+		Providers: map[string]Provider{
+			"a": &testProvider{addrs: []string{"10.0.0.1", "fe80::1", "10.0.0.2:8500", "[fe80::2]", "10.0.0.1"}},
+		},
+	}
+
+	results, err := d.Results(`provider=a port=8301 static=10.0.0.3,10.0.0.1:8301`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Result{
+		{"10.0.0.1:8301", "a"},
+		{"[fe80::1]:8301", "a"},
+		{"10.0.0.2:8500", "a"},
+		{"[fe80::2]:8301", "a"},
+		{"10.0.0.3:8301", "static"},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("got %v want %v", results, want)
+	}
+
+	for _, port := range []string{"0", "65536", "serf"} {
+		if _, err := d.Addrs("provider=a port="+port, nil); err == nil {
+			t.Fatalf("expected error for port %q", port)
+		}
+	}
+}
+
+// countingProvider records the maximum number of concurrent calls.
+type countingProvider struct {
+	mu      sync.Mutex
+	running int
+	max     int
+}
+
+func (p *countingProvider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	p.mu.Lock()
+	p.running++
+	if p.running > p.max {
+		p.max = p.running
+	}
+	p.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	p.mu.Lock()
+	p.running--
+	p.mu.Unlock()
+	return []string{args["addr"]}, nil
+}
+
+func (p *countingProvider) Help() string { return "" }
+
+func TestConcurrency(t *testing.T) {
+	for _, n := range []int{1, 2, 5} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			p := &countingProvider{}
+			d := Discover{
+				Providers:   map[string]Provider{"count": p},
+				Concurrency: n,
+			}
+
+			var cfgs []string
+			for i := 0; i < 20; i++ {
+				cfgs = append(cfgs, fmt.Sprintf("provider=count addr=10.0.0.%d", i))
+			}
+			addrs, err := d.Addrs(strings.Join(cfgs, " + "), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(addrs), 20; got != want {
+				t.Fatalf("got %d addrs want %d", got, want)
+			}
+			if p.max > n {
+				t.Fatalf("got %d concurrent calls want at most %d", p.max, n)
+			}
+		})
+	}
+}
+
+// concurrencyProvider records the concurrency passed with the context.
+type concurrencyProvider struct {
+	testProvider
+	mu  sync.Mutex
+	got []int
+}
+
+func (p *concurrencyProvider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
+	p.mu.Lock()
+	p.got = append(p.got, provider.ContextConcurrency(ctx, 0))
+	p.mu.Unlock()
+	return []string{args["addr"]}, nil
+}
+
+func TestConcurrencyContext(t *testing.T) {
+	p := &concurrencyProvider{}
+	d := Discover{
+		Providers:   map[string]Provider{"ctx": p},
+		Concurrency: 3,
+	}
+	if _, err := d.Addrs("provider=ctx addr=10.0.0.1 + provider=ctx addr=10.0.0.2", nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 3}; !reflect.DeepEqual(p.got, want) {
+		t.Fatalf("got concurrency %v want %v", p.got, want)
+	}
+}
+
+// userAgentProvider returns its user agent as address.
+type userAgentProvider struct {
+	userAgent string
+}
+
+func (p *userAgentProvider) SetUserAgent(s string) { p.userAgent = s }
+
+func (p *userAgentProvider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return []string{p.userAgent}, nil
+}
+
+func (p *userAgentProvider) Help() string { return "" }
+
+func TestUserAgent(t *testing.T) {
+	shared := &userAgentProvider{}
+	providers := map[string]Provider{"ua": shared}
+
+	var ds []*Discover
+	for _, agent := range []string{"a", "b"} {
+		d, err := New(WithProviders(providers), WithUserAgent(agent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds = append(ds, d)
+	}
+
+	for i, want := range []string{"a", "b"} {
+		addrs, err := ds[i].Addrs("provider=ua + provider=ua static=x", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(addrs, []string{want, "x"}) {
+			t.Fatalf("got %v want user agent %s", addrs, want)
+		}
+	}
+	if shared.userAgent != "" || providers["ua"] != shared {
+		t.Fatalf("shared provider modified")
+	}
+}
+
+// TestPingContextUserAgent pings the same provider for several configs
+// concurrently. Run it with -race to check that the provider is not
+// modified by the lookups.
+func TestPingContextUserAgent(t *testing.T) {
+	shared := &userAgentProvider{}
+	d, err := New(WithProviders(map[string]Provider{"ua": shared}), WithUserAgent("a"), WithConcurrency(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := "provider=ua + provider=ua + provider=ua + provider=ua"
+	for i := 0; i < 10; i++ {
+		if err := d.PingContext(context.Background(), cfg, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if shared.userAgent != "" {
+		t.Fatalf("shared provider modified")
+	}
+}
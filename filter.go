@@ -0,0 +1,198 @@
+package discover
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// localAddrs returns the addresses of the local network interfaces.
+var localAddrs = net.InterfaceAddrs
+
+// nodeOptions are the options of a config which are applied to the nodes
+// of every provider.
+type nodeOptions struct {
+	port  string
+	cidrs []*net.IPNet
+	dedup bool
+	sort  bool
+	limit int
+
+	// self contains the local addresses if exclude_self is set.
+	self []net.IP
+}
+
+// parseNodeOptions returns the node options of args.
+func parseNodeOptions(args Config) (nodeOptions, error) {
+	opts := nodeOptions{dedup: true}
+
+	if v := args["port"]; v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 65535 {
+			return opts, fmt.Errorf("discover: invalid port %q", v)
+		}
+		opts.port = v
+	}
+
+	if v := args["cidr_filter"]; v != "" {
+		for _, s := range strings.Split(v, ",") {
+			_, cidr, err := net.ParseCIDR(strings.TrimSpace(s))
+			if err != nil {
+				return opts, fmt.Errorf("discover: invalid cidr_filter %q", v)
+			}
+			opts.cidrs = append(opts.cidrs, cidr)
+		}
+	}
+
+	if v := args["limit"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return opts, fmt.Errorf("discover: invalid limit %q", v)
+		}
+		opts.limit = n
+	}
+
+	var excludeSelf bool
+	for _, opt := range []struct {
+		key string
+		b   *bool
+	}{
+		{"dedup", &opts.dedup},
+		{"sort", &opts.sort},
+		{"exclude_self", &excludeSelf},
+	} {
+		if v := args[opt.key]; v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return opts, fmt.Errorf("discover: invalid %s %q", opt.key, v)
+			}
+			*opt.b = b
+		}
+	}
+
+	if excludeSelf {
+		addrs, err := localAddrs()
+		if err != nil {
+			return opts, fmt.Errorf("discover: cannot determine local addresses: %s", err)
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				opts.self = append(opts.self, ipnet.IP)
+			}
+		}
+	}
+	return opts, nil
+}
+
+// apply appends the port, filters by CIDR, drops the local addresses and
+// the duplicates, sorts and limits the nodes in this order.
+func (o nodeOptions) apply(nodes []Node) []Node {
+	if o.port != "" {
+		nodes = appendPort(nodes, o.port)
+	}
+	if len(o.cidrs) > 0 {
+		nodes = filterCIDRs(nodes, o.cidrs)
+	}
+	if len(o.self) > 0 {
+		nodes = excludeIPs(nodes, o.self)
+	}
+	if o.dedup {
+		nodes = uniqueNodes(nodes)
+	}
+	if o.sort {
+		sortNodes(nodes)
+	}
+	if o.limit > 0 && len(nodes) > o.limit {
+		nodes = nodes[:o.limit]
+	}
+	return nodes
+}
+
+// appendPort appends the port to the addresses of all nodes which do not
+// have one yet. Addresses with a port, e.g. from providers which know the
+// service port of a node, are kept as is.
+func appendPort(nodes []Node, port string) []Node {
+	for i, n := range nodes {
+		nodes[i].Addr = withPort(n.Addr, port)
+	}
+	return nodes
+}
+
+// withPort appends the port to addr unless it already has one. IPv6
+// addresses are enclosed in brackets.
+func withPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	return net.JoinHostPort(host, port)
+}
+
+// filterCIDRs returns the nodes whose address is in one of the CIDRs.
+// Addresses which are host names never match.
+func filterCIDRs(nodes []Node, cidrs []*net.IPNet) []Node {
+	var filtered []Node
+	for _, n := range nodes {
+		ip := nodeIP(n.Addr)
+		if ip == nil {
+			continue
+		}
+		for _, cidr := range cidrs {
+			if cidr.Contains(ip) {
+				filtered = append(filtered, n)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// excludeIPs returns the nodes whose address is none of the IPs.
+func excludeIPs(nodes []Node, ips []net.IP) []Node {
+	var filtered []Node
+NodeLoop:
+	for _, n := range nodes {
+		if ip := nodeIP(n.Addr); ip != nil {
+			for _, self := range ips {
+				if ip.Equal(self) {
+					continue NodeLoop
+				}
+			}
+		}
+		filtered = append(filtered, n)
+	}
+	return filtered
+}
+
+// sortNodes sorts the nodes by IP address with IPv4 before IPv6 addresses
+// and host names last, and then by the address string.
+func sortNodes(nodes []Node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodeIP(nodes[i].Addr), nodeIP(nodes[j].Addr)
+		switch {
+		case a == nil && b == nil:
+			return nodes[i].Addr < nodes[j].Addr
+		case a == nil || b == nil:
+			return b == nil
+		}
+		a4, b4 := a.To4() != nil, b.To4() != nil
+		if a4 != b4 {
+			return a4
+		}
+		if c := bytes.Compare(a.To16(), b.To16()); c != 0 {
+			return c < 0
+		}
+		return nodes[i].Addr < nodes[j].Addr
+	})
+}
+
+// nodeIP returns the IP of an address with or without port or nil for host
+// names.
+func nodeIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+}
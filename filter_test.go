@@ -0,0 +1,119 @@
+package discover
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestNodeOptions(t *testing.T) {
+	d := Discover{
+		Providers: map[string]Provider{
+			"a": &testProvider{addrs: []string{"10.0.0.2", "fd00::1", "192.168.0.1", "consul.example.com", "10.0.0.10", "10.0.0.2", "1.2.3.4"}},
+		},
+	}
+
+	tests := []struct {
+		cfg   string
+		addrs []string
+	}{
+		{
+			`provider=a`,
+			[]string{"10.0.0.2", "fd00::1", "192.168.0.1", "consul.example.com", "10.0.0.10", "1.2.3.4"},
+		},
+		{
+			`provider=a dedup=false`,
+			[]string{"10.0.0.2", "fd00::1", "192.168.0.1", "consul.example.com", "10.0.0.10", "10.0.0.2", "1.2.3.4"},
+		},
+		{
+			`provider=a sort=true`,
+			[]string{"1.2.3.4", "10.0.0.2", "10.0.0.10", "192.168.0.1", "fd00::1", "consul.example.com"},
+		},
+		{
+			`provider=a cidr_filter=10.0.0.0/8`,
+			[]string{"10.0.0.2", "10.0.0.10"},
+		},
+		{
+			`provider=a cidr_filter="192.168.0.0/16, fd00::/8" port=8301 static=10.0.0.1`,
+			[]string{"[fd00::1]:8301", "192.168.0.1:8301"},
+		},
+		{
+			`provider=a cidr_filter=10.0.0.0/8 static=10.0.0.1,10.0.0.2:8301 port=8301 sort=true`,
+			[]string{"10.0.0.1:8301", "10.0.0.2:8301", "10.0.0.10:8301"},
+		},
+		{
+			`provider=a limit=2`,
+			[]string{"10.0.0.2", "fd00::1"},
+		},
+		{
+			`provider=a sort=true limit=3`,
+			[]string{"1.2.3.4", "10.0.0.2", "10.0.0.10"},
+		},
+		{
+			`provider=a limit=100 cidr_filter=fd00::/8`,
+			[]string{"fd00::1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cfg, func(t *testing.T) {
+			addrs, err := d.Addrs(tt.cfg, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(addrs, tt.addrs) {
+				t.Fatalf("got %v want %v", addrs, tt.addrs)
+			}
+		})
+	}
+
+	for _, cfg := range []string{
+		`provider=a dedup=maybe`,
+		`provider=a sort=yes-please`,
+		`provider=a cidr_filter=10.0.0.0`,
+		`provider=a cidr_filter=10.0.0.0/8,`,
+		`provider=a limit=0`,
+		`provider=a limit=all`,
+		`provider=a exclude_self=perhaps`,
+		`provider=a concurrency=0`,
+	} {
+		if _, err := d.Addrs(cfg, nil); err == nil {
+			t.Fatalf("expected error for %q", cfg)
+		}
+	}
+}
+
+func TestExcludeSelf(t *testing.T) {
+	defer func(f func() ([]net.Addr, error)) { localAddrs = f }(localAddrs)
+	localAddrs = func() ([]net.Addr, error) {
+		return []net.Addr{
+			&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+			&net.IPNet{IP: net.ParseIP("10.0.0.2"), Mask: net.CIDRMask(24, 32)},
+			&net.IPNet{IP: net.ParseIP("fd00::1"), Mask: net.CIDRMask(64, 128)},
+		}, nil
+	}
+
+	d := Discover{
+		Providers: map[string]Provider{
+			"a": &testProvider{addrs: []string{"10.0.0.1", "10.0.0.2", "[fd00::1]:8301", "fd00::2", "consul.example.com"}},
+		},
+	}
+
+	addrs, err := d.Addrs("provider=a exclude_self=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1", "fd00::2", "consul.example.com"}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v want %v", addrs, want)
+	}
+
+	// the local addresses are only looked up if needed
+	localAddrs = func() ([]net.Addr, error) { return nil, errors.New("no interfaces") }
+	if _, err := d.Addrs("provider=a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Addrs("provider=a exclude_self=true", nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,152 @@
+package discover
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/go-discover/provider"
+)
+
+// Logger is a leveled, structured logger like hclog.Logger. See SetLogger.
+type Logger = provider.Logger
+
+// LogLevel is the minimum level of the log messages which are passed on to
+// the logger. The level of a message is taken from its "[DEBUG]", "[INFO]",
+// "[WARN]" or "[ERR]" prefix. Messages without a known prefix are always
+// logged.
+type LogLevel int
+
+const (
+	// LogLevelDebug logs all messages. This is the default.
+	LogLevelDebug LogLevel = iota
+
+	// LogLevelInfo drops "[DEBUG]" messages.
+	LogLevelInfo
+
+	// LogLevelWarn drops "[DEBUG]" and "[INFO]" messages.
+	LogLevelWarn
+
+	// LogLevelError only logs "[ERR]" messages.
+	LogLevelError
+)
+
+// levels maps the message prefixes to their log level.
+var levels = map[string]LogLevel{
+	"TRACE": LogLevelDebug,
+	"DEBUG": LogLevelDebug,
+	"INFO":  LogLevelInfo,
+	"WARN":  LogLevelWarn,
+	"ERR":   LogLevelError,
+	"ERROR": LogLevelError,
+}
+
+// levelLogger returns a logger which writes to the same destination as l but
+// drops all messages below the given level.
+func levelLogger(l *log.Logger, level LogLevel) *log.Logger {
+	if level <= LogLevelDebug {
+		return l
+	}
+	w := &levelWriter{w: l.Writer(), level: level}
+	return log.New(w, l.Prefix(), l.Flags())
+}
+
+// levelWriter drops all log lines below the configured level.
+type levelWriter struct {
+	w     io.Writer
+	level LogLevel
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	if lineLevel(p) < w.level {
+		return len(p), nil
+	}
+	return w.w.Write(p)
+}
+
+// lineLevel returns the level of a log line by looking at its first known
+// "[LEVEL]" tag. Lines without a known tag have the highest level.
+func lineLevel(p []byte) LogLevel {
+	line := string(p)
+	start, end := findTag(line)
+	if start < 0 {
+		return LogLevelError
+	}
+	return levels[line[start+1:end]]
+}
+
+// findTag returns the positions of the brackets of the first "[LEVEL]" tag
+// with a known level in a log line or -1 if there is none. Other brackets,
+// e.g. in the prefix of the logger or around an IPv6 address, are skipped.
+func findTag(line string) (start, end int) {
+	for i := 0; i < len(line); i = start + 1 {
+		start = strings.IndexByte(line[i:], '[')
+		if start < 0 {
+			break
+		}
+		start += i
+		end = strings.IndexByte(line[start:], ']')
+		if end < 0 {
+			break
+		}
+		end += start
+		if _, ok := levels[line[start+1:end]]; ok {
+			return start, end
+		}
+	}
+	return -1, -1
+}
+
+// stdLogger returns the logger to pass to the providers for l. If a Logger
+// is set all messages are sent to it instead of l. Messages below the log
+// level are dropped.
+func (d *Discover) stdLogger(l *log.Logger) *log.Logger {
+	switch {
+	case d.logger != nil:
+		l = log.New(&loggerWriter{l: d.logger}, "", 0)
+	case l == nil:
+		l = log.New(ioutil.Discard, "", 0)
+	}
+	return levelLogger(l, d.LogLevel)
+}
+
+// loggerWriter sends every log line to the Logger method matching its
+// "[LEVEL]" tag with the tag removed. Lines without a known tag are logged
+// at info level.
+type loggerWriter struct {
+	l Logger
+}
+
+func (w *loggerWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		tag, msg := splitTag(line)
+		switch tag {
+		case "TRACE":
+			w.l.Trace(msg)
+		case "DEBUG":
+			w.l.Debug(msg)
+		case "WARN":
+			w.l.Warn(msg)
+		case "ERR", "ERROR":
+			w.l.Error(msg)
+		default:
+			w.l.Info(msg)
+		}
+	}
+	return len(p), nil
+}
+
+// splitTag returns the first known "[LEVEL]" tag of a log line and the line
+// without it.
+func splitTag(line string) (tag, msg string) {
+	start, end := findTag(line)
+	if start < 0 {
+		return "", line
+	}
+	before, after := strings.TrimSpace(line[:start]), strings.TrimSpace(line[end+1:])
+	if before == "" {
+		return line[start+1 : end], after
+	}
+	return line[start+1 : end], strings.TrimSpace(before + " " + after)
+}
@@ -0,0 +1,111 @@
+package discover
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"testing"
+)
+
+func TestLevelLogger(t *testing.T) {
+	tests := []struct {
+		level LogLevel
+		out   string
+	}{
+		{LogLevelDebug, "x [DEBUG] a\nx [INFO] b\nx [WARN] c\nx [ERR] d\nx e\n"},
+		{LogLevelInfo, "x [INFO] b\nx [WARN] c\nx [ERR] d\nx e\n"},
+		{LogLevelWarn, "x [WARN] c\nx [ERR] d\nx e\n"},
+		{LogLevelError, "x [ERR] d\nx e\n"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		l := levelLogger(log.New(&buf, "x ", 0), tt.level)
+		l.Printf("[DEBUG] a")
+		l.Printf("[INFO] b")
+		l.Printf("[WARN] c")
+		l.Printf("[ERR] d")
+		l.Printf("e")
+		if got, want := buf.String(), tt.out; got != want {
+			t.Fatalf("level %d: got %q want %q", tt.level, got, want)
+		}
+	}
+}
+
+func TestLevelLoggerBracketPrefix(t *testing.T) {
+	var buf bytes.Buffer
+	l := levelLogger(log.New(&buf, "[agent] ", 0), LogLevelInfo)
+	l.Printf("[DEBUG] a")
+	l.Printf("[INFO] b [2001:db8::1]:8301")
+	l.Printf("c [x]")
+	if got, want := buf.String(), "[agent] [INFO] b [2001:db8::1]:8301\n[agent] c [x]\n"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+// recordingLogger records the messages with their level.
+type recordingLogger struct {
+	msgs []string
+}
+
+func (l *recordingLogger) log(level, msg string) { l.msgs = append(l.msgs, level+" "+msg) }
+
+func (l *recordingLogger) Trace(msg string, args ...interface{}) { l.log("trace", msg) }
+func (l *recordingLogger) Debug(msg string, args ...interface{}) { l.log("debug", msg) }
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.log("info", msg) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.log("warn", msg) }
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.log("error", msg) }
+
+func TestLoggerWriter(t *testing.T) {
+	rec := &recordingLogger{}
+	l := log.New(&loggerWriter{l: rec}, "", 0)
+	l.Printf("[TRACE] a")
+	l.Printf("[DEBUG] discover-x: b")
+	l.Printf("[INFO] c")
+	l.Printf("[WARN] d")
+	l.Printf("[ERR] e")
+	l.Printf("[ERROR] f")
+	l.Printf("g [x]")
+	l.Printf("h\n[DEBUG] i")
+	l.Printf("[x] [WARN] j")
+
+	want := []string{
+		"trace a", "debug discover-x: b", "info c", "warn d", "error e",
+		"error f", "info g [x]", "info h", "debug i", "warn [x] j",
+	}
+	if !reflect.DeepEqual(rec.msgs, want) {
+		t.Fatalf("got %q want %q", rec.msgs, want)
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	rec := &recordingLogger{}
+	d := Discover{
+		Providers: map[string]Provider{"ok": &testProvider{addrs: []string{"1.2.3.4"}}},
+		LogLevel:  LogLevelInfo,
+	}
+	d.SetLogger(rec)
+
+	var buf bytes.Buffer
+	if _, err := d.Addrs("provider=ok", log.New(&buf, "", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Addrs("provider=nope", log.New(&buf, "", 0)); err == nil {
+		t.Fatal("expected error")
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("got output %q on the standard logger", buf.String())
+	}
+	// the debug messages are dropped by the log level
+	if len(rec.msgs) != 0 {
+		t.Fatalf("got messages %q want none", rec.msgs)
+	}
+
+	d.LogLevel = LogLevelDebug
+	if _, err := d.Addrs("provider=ok", nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`debug discover: Using provider "ok"`}; !reflect.DeepEqual(rec.msgs, want) {
+		t.Fatalf("got %q want %q", rec.msgs, want)
+	}
+}
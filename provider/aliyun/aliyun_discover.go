@@ -2,12 +2,14 @@
 package aliyun
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 
 	"github.com/denverdino/aliyungo/common"
 	"github.com/denverdino/aliyungo/ecs"
+	"github.com/hashicorp/go-discover/provider"
 )
 
 type Provider struct {
@@ -34,6 +36,20 @@ func (p *Provider) Help() string {
 }
 
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and returns when ctx is
+// done. The aliyungo client does not accept a context, so the DescribeInstances
+// calls of an abandoned lookup are not aborted.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
+	return provider.LookupContext(ctx, func() ([]string, error) {
+		return p.addrs(args, l)
+	})
+}
+
+// addrs looks up the addresses without a context.
+func (p *Provider) addrs(args map[string]string, l *log.Logger) ([]string, error) {
 	if args["provider"] != "aliyun" {
 		return nil, fmt.Errorf("discover-aliyun: invalid provider " + args["provider"])
 	}
@@ -11,6 +11,7 @@ import (
 
 var _ discover.Provider = (*aliyun.Provider)(nil)
 var _ discover.ProviderWithUserAgent = (*aliyun.Provider)(nil)
+var _ discover.ProviderWithContext = (*aliyun.Provider)(nil)
 
 func TestAddrs(t *testing.T) {
 	args := discover.Config{
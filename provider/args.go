@@ -0,0 +1,12 @@
+package provider
+
+import "os"
+
+// ArgsOrEnv returns the value of the argument key or of the environment
+// variable env if the argument is not set.
+func ArgsOrEnv(args map[string]string, key, env string) string {
+	if value := args[key]; value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
@@ -0,0 +1,21 @@
+package provider
+
+import (
+	"os"
+	"testing"
+)
+
+func TestArgsOrEnv(t *testing.T) {
+	os.Setenv("DISCOVER_TEST_ARG", "env")
+	defer os.Unsetenv("DISCOVER_TEST_ARG")
+
+	if got := ArgsOrEnv(map[string]string{"key": "arg"}, "key", "DISCOVER_TEST_ARG"); got != "arg" {
+		t.Fatalf("got %q want %q", got, "arg")
+	}
+	if got := ArgsOrEnv(map[string]string{}, "key", "DISCOVER_TEST_ARG"); got != "env" {
+		t.Fatalf("got %q want %q", got, "env")
+	}
+	if got := ArgsOrEnv(map[string]string{}, "key", "DISCOVER_TEST_MISSING"); got != "" {
+		t.Fatalf("got %q want empty", got)
+	}
+}
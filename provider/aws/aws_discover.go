@@ -2,9 +2,11 @@
 package aws
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ecs"
 	"io/ioutil"
 	"log"
@@ -40,6 +42,9 @@ func (p *Provider) Help() string {
     service:           The AWS service to filter. "ec2" or "ecs". Defaults to "ec2".
     ecs_cluster:       The AWS ECS Cluster Name or Full ARN to limit searching within. Default none, search all.
     ecs_family:        The AWS ECS Task Definition Family to limit searching within. Default none, search all.
+    ecs_service:       The AWS ECS Service Name to limit searching within. Default none, search all.
+                       Cannot be used with ecs_family. Without ecs_cluster the clusters which do
+                       not have the service are skipped.
     endpoint:          The endpoint URL of the AWS Service to use. If not set the AWS
                        client will set this value, which defaults to the public DNS name
                        for the service in the specified region.
@@ -49,17 +54,26 @@ func (p *Provider) Help() string {
     otherwise it is recommended you make a dedicated IAM user and access key used only
     for auto-joining.
 
-    For ECS discovery the following IAM permissions are required on the AWS ECS Task Role
+    For ECS discovery the private IPv4 address or, with addr_type "public_v4", the public
+    IPv4 address of the task ENI is returned. Tasks are filtered by tag_key and tag_value
+    if set. The following IAM permissions are required on the AWS ECS Task Role
     associated with the Service performing discovery.
 		"ecs:ListClusters"
 		"ecs:ListServices"
 		"ecs:DescribeServices"
 		"ecs:ListTasks"
 		"ecs:DescribeTasks"
+		"ec2:DescribeNetworkInterfaces" (only for addr_type "public_v4")
 `
 }
 
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and aborts the API calls
+// when ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
 	if args["provider"] != "aws" {
 		return nil, fmt.Errorf("discover-aws: invalid provider " + args["provider"])
 	}
@@ -78,13 +92,18 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 	service := args["service"]
 	ecsCluster := args["ecs_cluster"]
 	ecsFamily := args["ecs_family"]
+	ecsService := args["ecs_service"]
 	endpoint := args["endpoint"]
 
+	if ecsFamily != "" && ecsService != "" {
+		return nil, fmt.Errorf("discover-aws: ecs_family and ecs_service cannot be used together")
+	}
+
 	if service != "ec2" && service != "ecs" {
 		l.Printf("[INFO] discover-aws: Service type %s is not supported. Valid values are {ec2,ecs}. Falling back to 'ec2'", service)
 		service = "ec2"
-	} else if service == "ecs" && addrType != "private_v4" {
-		l.Printf("[INFO] discover-aws: Address Type %s is not supported for ECS. Valid values are {private_v4}. Falling back to 'private_v4'", addrType)
+	} else if service == "ecs" && addrType != "private_v4" && addrType != "public_v4" {
+		l.Printf("[INFO] discover-aws: Address Type %s is not supported for ECS. Valid values are {private_v4,public_v4}. Falling back to 'private_v4'", addrType)
 		addrType = "private_v4"
 	}
 
@@ -163,7 +182,7 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 
 		// If an ECS Cluster Name (ARN) was specified, dont lookup all the cluster arns
 		if ecsCluster == "" {
-			arns, err := getEcsClusters(svc)
+			arns, err := getEcsClusters(ctx, svc)
 			if err != nil {
 				return nil, fmt.Errorf("discover-aws: Failed to get ECS clusters: %s", err)
 			}
@@ -172,11 +191,23 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 			clusterArns = []*string{&ecsCluster}
 		}
 
+		// The public IPs aren't part of the task description and are looked
+		// up with the ids of the task ENIs.
+		detail := "privateIPv4Address"
+		if addrType == "public_v4" {
+			detail = "networkInterfaceId"
+		}
+
 		var taskIps []string
 		for _, clusterArn := range clusterArns {
-			taskArns, err := getEcsTasks(svc, clusterArn, &ecsFamily)
+			taskArns, err := getEcsTasks(ctx, svc, clusterArn, &ecsFamily, &ecsService)
+			if ecsCluster == "" && isServiceNotFound(err) {
+				// only some of the clusters have the service
+				l.Printf("[DEBUG] discover-aws: ECS cluster %s has no service %s", aws.StringValue(clusterArn), ecsService)
+				continue
+			}
 			if err != nil {
-				return nil, fmt.Errorf("discover-aws: Failed to get ECS Tasks: %s", err)
+				return nil, fmt.Errorf("discover-aws: Failed to get ECS Tasks: ListTasks failed: %s", err)
 			}
 			log.Printf("[DEBUG] discover-aws: Found %d ECS Tasks", len(taskArns))
 
@@ -185,7 +216,7 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 			pageLimit := 100
 			for i := 0; i < len(taskArns); i += pageLimit {
 				taskGroup := taskArns[i:min(i+pageLimit, len(taskArns))]
-				ecsTaskIps, err := getEcsTaskIps(svc, clusterArn, taskGroup, &tagKey, &tagValue)
+				ecsTaskIps, err := getEcsTaskIps(ctx, svc, clusterArn, taskGroup, &tagKey, &tagValue, detail)
 				if err != nil {
 					return nil, fmt.Errorf("discover-aws: Failed to get ECS Task IPs: %s", err)
 				}
@@ -193,6 +224,13 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 				log.Printf("[DEBUG] discover-aws: Found %d ECS IPs", len(ecsTaskIps))
 			}
 		}
+		if addrType == "public_v4" && len(taskIps) > 0 {
+			eniIps, err := getEniPublicIps(ctx, ec2.New(session.New(), &config), taskIps)
+			if err != nil {
+				return nil, fmt.Errorf("discover-aws: Failed to get ECS Task public IPs: %s", err)
+			}
+			taskIps = eniIps
+		}
 		log.Printf("[DEBUG] discover-aws: Discovered ECS Task IPs: %v", taskIps)
 		return taskIps, nil
 	}
@@ -202,7 +240,7 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 	svc := ec2.New(session.New(), &config)
 
 	l.Printf("[INFO] discover-aws: Filter instances with %s=%s", tagKey, tagValue)
-	resp, err := svc.DescribeInstances(&ec2.DescribeInstancesInput{
+	resp, err := svc.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
 		Filters: []*ec2.Filter{
 			&ec2.Filter{
 				Name:   aws.String("tag:" + tagKey),
@@ -276,10 +314,10 @@ func min(a, b int) int {
 	return b
 }
 
-func getEcsClusters(svc *ecs.ECS) ([]*string, error) {
+func getEcsClusters(ctx context.Context, svc *ecs.ECS) ([]*string, error) {
 	pageNum := 0
 	var clusterArns []*string
-	err := svc.ListClustersPages(&ecs.ListClustersInput{}, func(page *ecs.ListClustersOutput, lastPage bool) bool {
+	err := svc.ListClustersPagesWithContext(ctx, &ecs.ListClustersInput{}, func(page *ecs.ListClustersOutput, lastPage bool) bool {
 		pageNum++
 		clusterArns = append(clusterArns, page.ClusterArns...)
 		log.Printf("[DEBUG] discover-aws: Retrieved %d TaskArns from page %d", len(clusterArns), pageNum)
@@ -325,7 +363,7 @@ func getEcsTaskRegion(e ECSTaskMeta) (string, error) {
 	return a.Region, nil
 }
 
-func getEcsTasks(svc *ecs.ECS, clusterArn *string, family *string) ([]*string, error) {
+func getEcsTasks(ctx context.Context, svc *ecs.ECS, clusterArn *string, family *string, serviceName *string) ([]*string, error) {
 	var taskArns []*string
 	lti := ecs.ListTasksInput{
 		Cluster:       clusterArn,
@@ -334,9 +372,12 @@ func getEcsTasks(svc *ecs.ECS, clusterArn *string, family *string) ([]*string, e
 	if *family != "" {
 		lti.Family = family
 	}
+	if *serviceName != "" {
+		lti.ServiceName = serviceName
+	}
 
 	pageNum := 0
-	err := svc.ListTasksPages(&lti, func(page *ecs.ListTasksOutput, lastPage bool) bool {
+	err := svc.ListTasksPagesWithContext(ctx, &lti, func(page *ecs.ListTasksOutput, lastPage bool) bool {
 		pageNum++
 		taskArns = append(taskArns, page.TaskArns...)
 		log.Printf("[DEBUG] discover-aws: Retrieved %d TaskArns from page %d", len(taskArns), pageNum)
@@ -344,15 +385,22 @@ func getEcsTasks(svc *ecs.ECS, clusterArn *string, family *string) ([]*string, e
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("ListTasks failed: %s", err)
+		return nil, err
 	}
 
 	return taskArns, nil
 }
 
-func getEcsTaskIps(svc *ecs.ECS, clusterArn *string, taskArns []*string, tagKey *string, tagValue *string) ([]string, error) {
+// isServiceNotFound returns true if err is the error of ListTasks for a
+// service which does not exist in the cluster.
+func isServiceNotFound(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == ecs.ErrCodeServiceNotFoundException
+}
+
+func getEcsTaskIps(ctx context.Context, svc *ecs.ECS, clusterArn *string, taskArns []*string, tagKey *string, tagValue *string, detail string) ([]string, error) {
 	// Describe all the tasks listed for this cluster
-	taskDescriptions, err := svc.DescribeTasks(&ecs.DescribeTasksInput{
+	taskDescriptions, err := svc.DescribeTasksWithContext(ctx, &ecs.DescribeTasksInput{
 		Cluster: clusterArn,
 		Include: []*string{aws.String(ecs.TaskFieldTags)},
 		Tasks:   taskArns,
@@ -366,43 +414,51 @@ func getEcsTaskIps(svc *ecs.ECS, clusterArn *string, taskArns []*string, tagKey
 	tasks := taskDescriptions.Tasks
 	log.Printf("[INFO] discover-aws: Retrieved %d Task Descriptions and %d Failures", len(tasks), len(taskRequestFailures))
 
-	// Filter tasks by Tag and Connectivity Status
-	var ipList []string
-	for _, taskDescription := range tasks {
-
-		for _, tag := range taskDescription.Tags {
-			if *tag.Key == *tagKey && *tag.Value == *tagValue {
-				log.Printf("[DEBUG] discover-aws: Tag Match: %s : %s, desiredStatus: %s", *tag.Key, *tag.Value, *taskDescription.DesiredStatus)
-
-				if *taskDescription.DesiredStatus == "RUNNING" {
-					log.Printf("[INFO] discover-aws: Found Running Instance: %s", *taskDescription.TaskArn)
-					ip := getIpFromTaskDescription(taskDescription)
-
-					if ip != nil {
-						log.Printf("[DEBUG] discover-aws: Found Private IP: %s", *ip)
-						ipList = append(ipList, *ip)
-					}
+	ipList := filterEcsTasks(tasks, *tagKey, *tagValue, detail)
+	log.Printf("[INFO] discover-aws: Retrieved %d IPs from %d Tasks", len(ipList), len(taskArns))
+	return ipList, nil
+}
 
-				}
+// filterEcsTasks returns the attachment detail of the running tasks with the
+// tag. All running tasks match if tagKey is empty.
+func filterEcsTasks(tasks []*ecs.Task, tagKey, tagValue, detail string) []string {
+	var values []string
+	for _, taskDescription := range tasks {
+		if tagKey != "" && !hasEcsTag(taskDescription, tagKey, tagValue) {
+			continue
+		}
+		log.Printf("[DEBUG] discover-aws: Tag Match: %s : %s, desiredStatus: %s", tagKey, tagValue, aws.StringValue(taskDescription.DesiredStatus))
 
-			}
+		if aws.StringValue(taskDescription.DesiredStatus) != "RUNNING" {
+			continue
+		}
+		log.Printf("[INFO] discover-aws: Found Running Instance: %s", aws.StringValue(taskDescription.TaskArn))
 
+		if v := getDetailFromTaskDescription(taskDescription, detail); v != nil {
+			log.Printf("[DEBUG] discover-aws: Found %s: %s", detail, *v)
+			values = append(values, *v)
 		}
 	}
+	return values
+}
 
-	log.Printf("[INFO] discover-aws: Retrieved %d IPs from %d Tasks", len(ipList), len(taskArns))
-	return ipList, nil
+func hasEcsTag(taskDesc *ecs.Task, key, value string) bool {
+	for _, tag := range taskDesc.Tags {
+		if aws.StringValue(tag.Key) == key && aws.StringValue(tag.Value) == value {
+			return true
+		}
+	}
+	return false
 }
 
-func getIpFromTaskDescription(taskDesc *ecs.Task) *string {
-	log.Printf("[DEBUG] discover-aws: Searching %d attachments for IPs", len(taskDesc.Attachments))
+func getDetailFromTaskDescription(taskDesc *ecs.Task, name string) *string {
+	log.Printf("[DEBUG] discover-aws: Searching %d attachments for %s", len(taskDesc.Attachments), name)
 	for _, attachment := range taskDesc.Attachments {
 
-		log.Printf("[DEBUG] discover-aws: Searching %d attachment details for IPs", len(attachment.Details))
+		log.Printf("[DEBUG] discover-aws: Searching %d attachment details for %s", len(attachment.Details), name)
 		for _, detail := range attachment.Details {
 
-			if *detail.Name == "privateIPv4Address" {
-				log.Printf("[DEBUG] discover-aws: Parsing Private IPv4: %s", *detail.Value)
+			if aws.StringValue(detail.Name) == name {
 				return detail.Value
 			}
 
@@ -411,3 +467,26 @@ func getIpFromTaskDescription(taskDesc *ecs.Task) *string {
 	}
 	return nil
 }
+
+// getEniPublicIps returns the public IPv4 addresses of the network
+// interfaces. Interfaces without a public IP are skipped.
+func getEniPublicIps(ctx context.Context, svc *ec2.EC2, eniIds []string) ([]string, error) {
+	var ips []string
+	err := svc.DescribeNetworkInterfacesPagesWithContext(ctx, &ec2.DescribeNetworkInterfacesInput{
+		NetworkInterfaceIds: aws.StringSlice(eniIds),
+	}, func(page *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+		for _, eni := range page.NetworkInterfaces {
+			if eni.Association == nil || eni.Association.PublicIp == nil {
+				log.Printf("[DEBUG] discover-aws: Network interface %s has no public IPv4", aws.StringValue(eni.NetworkInterfaceId))
+				continue
+			}
+			log.Printf("[DEBUG] discover-aws: Network interface %s has public IPv4 %s", aws.StringValue(eni.NetworkInterfaceId), *eni.Association.PublicIp)
+			ips = append(ips, *eni.Association.PublicIp)
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DescribeNetworkInterfaces failed: %s", err)
+	}
+	return ips, nil
+}
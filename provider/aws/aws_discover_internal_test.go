@@ -0,0 +1,153 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func ecsTask(status, ip, eni string, tags map[string]string) *ecs.Task {
+	t := &ecs.Task{
+		DesiredStatus: aws.String(status),
+		TaskArn:       aws.String("arn:aws:ecs:us-east-1:000000000000:task/cluster/" + ip),
+		Attachments: []*ecs.Attachment{{
+			Details: []*ecs.KeyValuePair{
+				{Name: aws.String("subnetId"), Value: aws.String("subnet-1")},
+				{Name: aws.String("networkInterfaceId"), Value: aws.String(eni)},
+				{Name: aws.String("privateIPv4Address"), Value: aws.String(ip)},
+			},
+		}},
+	}
+	for k, v := range tags {
+		t.Tags = append(t.Tags, &ecs.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return t
+}
+
+func TestFilterEcsTasks(t *testing.T) {
+	tasks := []*ecs.Task{
+		ecsTask("RUNNING", "10.0.0.1", "eni-1", map[string]string{"consul": "server"}),
+		ecsTask("RUNNING", "10.0.0.2", "eni-2", map[string]string{"consul": "client"}),
+		ecsTask("STOPPED", "10.0.0.3", "eni-3", map[string]string{"consul": "server"}),
+		ecsTask("RUNNING", "10.0.0.4", "eni-4", nil),
+		{DesiredStatus: aws.String("RUNNING"), TaskArn: aws.String("no-attachments")},
+	}
+
+	tests := []struct {
+		name     string
+		tagKey   string
+		tagValue string
+		detail   string
+		want     []string
+	}{
+		{"tag", "consul", "server", "privateIPv4Address", []string{"10.0.0.1"}},
+		{"no tag", "", "", "privateIPv4Address", []string{"10.0.0.1", "10.0.0.2", "10.0.0.4"}},
+		{"eni", "consul", "client", "networkInterfaceId", []string{"eni-2"}},
+		{"no match", "consul", "other", "privateIPv4Address", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := filterEcsTasks(tasks, tt.tagKey, tt.tagValue, tt.detail)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testECSServer is a fake ECS API with the clusters a and b. Only cluster a
+// has the service consul.
+func testECSServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in struct {
+			Cluster     string `json:"cluster"`
+			Family      string `json:"family"`
+			ServiceName string `json:"serviceName"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			t.Error(err)
+		}
+		if in.Family != "" && in.ServiceName != "" {
+			t.Errorf("got family %q and serviceName %q", in.Family, in.ServiceName)
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonEC2ContainerServiceV20141113.ListClusters":
+			fmt.Fprint(w, `{"clusterArns": ["a", "b"]}`)
+		case "AmazonEC2ContainerServiceV20141113.ListTasks":
+			if in.Cluster != "a" && in.ServiceName != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type": "ServiceNotFoundException", "message": "Service not found."}`)
+				return
+			}
+			fmt.Fprintf(w, `{"taskArns": ["%s/1"]}`, in.Cluster)
+		case "AmazonEC2ContainerServiceV20141113.DescribeTasks":
+			ip := "10.0.0.1"
+			if in.Cluster == "b" {
+				ip = "10.0.0.2"
+			}
+			fmt.Fprintf(w, `{"tasks": [{"taskArn": "%s/1", "desiredStatus": "RUNNING", "attachments": [{"details": [{"name": "privateIPv4Address", "value": "%s"}]}]}]}`, in.Cluster, ip)
+		default:
+			http.Error(w, "unexpected "+r.Header.Get("X-Amz-Target"), http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestAddrsECSService(t *testing.T) {
+	ts := testECSServer(t)
+	defer ts.Close()
+
+	tests := []struct {
+		name  string
+		args  map[string]string
+		addrs []string
+		err   bool
+	}{
+		{"all clusters", map[string]string{}, []string{"10.0.0.1", "10.0.0.2"}, false},
+		{"service in all clusters", map[string]string{"ecs_service": "consul"}, []string{"10.0.0.1"}, false},
+		{"service in cluster", map[string]string{"ecs_service": "consul", "ecs_cluster": "a"}, []string{"10.0.0.1"}, false},
+		{"service not in cluster", map[string]string{"ecs_service": "consul", "ecs_cluster": "b"}, nil, true},
+		{"family and service", map[string]string{"ecs_service": "consul", "ecs_family": "consul"}, nil, true},
+	}
+
+	p := &Provider{}
+	l := log.New(ioutil.Discard, "", 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := map[string]string{
+				"provider":          "aws",
+				"service":           "ecs",
+				"region":            "us-east-1",
+				"access_key_id":     "id",
+				"secret_access_key": "secret",
+				"endpoint":          ts.URL,
+			}
+			for k, v := range tt.args {
+				args[k] = v
+			}
+			addrs, err := p.Addrs(args, l)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(addrs, tt.addrs) {
+				t.Fatalf("got %v want %v", addrs, tt.addrs)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package azure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+)
+
+// firstNonEmpty returns the first value which is not empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// federatedTokenSecret authenticates with a federated token, e.g. the
+// service account token of an AKS workload identity. The file is read for
+// every token request since the token is rotated.
+type federatedTokenSecret struct {
+	file string
+}
+
+// SetAuthenticationValues implements adal.ServicePrincipalSecret.
+func (s *federatedTokenSecret) SetAuthenticationValues(spt *adal.ServicePrincipalToken, v *url.Values) error {
+	b, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return fmt.Errorf("reading federated token: %s", err)
+	}
+	v.Set("client_assertion", strings.TrimSpace(string(b)))
+	v.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	return nil
+}
+
+// newAuthorizer returns the authorizer for the auth_type argument. Without
+// auth_type client credentials are used if a secret is set, a workload
+// identity if a federated token file is set and the environment based
+// authentication of the Azure SDK otherwise.
+func newAuthorizer(args map[string]string, l *log.Logger) (autorest.Authorizer, error) {
+	tenantID := firstNonEmpty(argsOrEnv(args, "tenant_id", "ARM_TENANT_ID"), os.Getenv("AZURE_TENANT_ID"))
+	clientID := firstNonEmpty(argsOrEnv(args, "client_id", "ARM_CLIENT_ID"), os.Getenv("AZURE_CLIENT_ID"))
+	secretKey := argsOrEnv(args, "secret_access_key", "ARM_CLIENT_SECRET")
+	tokenFile := argsOrEnv(args, "federated_token_file", "AZURE_FEDERATED_TOKEN_FILE")
+
+	authType := args["auth_type"]
+	if authType == "" {
+		switch {
+		case tenantID != "" && clientID != "" && secretKey != "":
+			authType = "client_secret"
+		case tokenFile != "":
+			authType = "workload_identity"
+		default:
+			authType = "environment"
+		}
+	}
+	l.Printf("[DEBUG] discover-azure: using auth_type %s", authType)
+
+	switch authType {
+	case "client_secret":
+		a, err := auth.NewClientCredentialsConfig(clientID, secretKey, tenantID).Authorizer()
+		if err != nil {
+			return nil, fmt.Errorf("discover-azure (ClientCredentials): %s", err)
+		}
+		return a, nil
+
+	case "msi":
+		// client_id selects a user-assigned identity, without it the
+		// system-assigned identity is used.
+		spt, err := adal.NewServicePrincipalTokenFromManagedIdentity(azure.PublicCloud.ResourceManagerEndpoint, &adal.ManagedIdentityOptions{ClientID: clientID})
+		if err != nil {
+			return nil, fmt.Errorf("discover-azure (ManagedIdentity): %s", err)
+		}
+		return autorest.NewBearerAuthorizer(spt), nil
+
+	case "workload_identity":
+		if tenantID == "" || clientID == "" || tokenFile == "" {
+			return nil, fmt.Errorf("discover-azure (WorkloadIdentity): tenant_id, client_id and federated_token_file are required")
+		}
+		authority := firstNonEmpty(os.Getenv("AZURE_AUTHORITY_HOST"), azure.PublicCloud.ActiveDirectoryEndpoint)
+		oauthConfig, err := adal.NewOAuthConfig(authority, tenantID)
+		if err != nil {
+			return nil, fmt.Errorf("discover-azure (WorkloadIdentity): %s", err)
+		}
+		spt, err := adal.NewServicePrincipalTokenWithSecret(*oauthConfig, clientID, azure.PublicCloud.ResourceManagerEndpoint, &federatedTokenSecret{file: tokenFile})
+		if err != nil {
+			return nil, fmt.Errorf("discover-azure (WorkloadIdentity): %s", err)
+		}
+		return autorest.NewBearerAuthorizer(spt), nil
+
+	case "environment":
+		a, err := auth.NewAuthorizerFromEnvironment()
+		if err != nil {
+			return nil, fmt.Errorf("discover-azure (EnvironmentCredentials): %s", err)
+		}
+		return a, nil
+
+	default:
+		return nil, fmt.Errorf("discover-azure: invalid auth_type %q", authType)
+	}
+}
@@ -7,10 +7,11 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2015-06-15/network"
 	"github.com/Azure/go-autorest/autorest"
-	"github.com/Azure/go-autorest/autorest/azure/auth"
 )
 
 type Provider struct {
@@ -32,12 +33,23 @@ func (p *Provider) Help() string {
     **NOTE** The secret_access_key value often may have an equals sign in it's value,
     especially if generated from the Azure Portal. So is important to wrap in single quotes
     eg. secret_acccess_key='fpOfcHQJAQBczjAxiVpeyLmX1M0M0KPBST+GU2GvEN4='
+   auth_type:         "client_secret", "msi", "workload_identity" or "environment".
+                      (default: "client_secret" if tenant_id, client_id and
+                      secret_access_key are set, "workload_identity" if
+                      federated_token_file is set, "environment" otherwise)
+   federated_token_file: The file of the federated token for "workload_identity"
 
    Variables can also be provided by environmental variables:
     export ARM_SUBSCRIPTION_ID for subscription
     export ARM_TENANT_ID for tenant
     export ARM_CLIENT_ID for client
     export ARM_CLIENT_SECRET for secret access key
+    export AZURE_FEDERATED_TOKEN_FILE for federated token file
+
+   The AZURE_TENANT_ID, AZURE_CLIENT_ID, AZURE_FEDERATED_TOKEN_FILE and
+   AZURE_AUTHORITY_HOST variables set by the AKS workload identity webhook are
+   also used. With "msi" the client_id selects a user-assigned managed identity,
+   without it the system-assigned identity is used.
 
    If none of those options are given, the Azure SDK is using the default  environment based authentication outlined
    here https://docs.microsoft.com/en-us/go/azure/azure-sdk-go-authorization#use-environment-based-authentication
@@ -52,10 +64,14 @@ func (p *Provider) Help() string {
 
    resource_group:    The name of the resource group to filter on
    vm_scale_set:      The name of the virtual machine scale set to filter on
+   orchestration_mode: "uniform" or "flexible", the orchestration mode of the
+                      virtual machine scale set. (default: "uniform")
 
    When using tags the only permission needed is Microsoft.Network/networkInterfaces/*
 
    When using Virtual Machine Scale Sets the only role action needed is Microsoft.Compute/virtualMachineScaleSets/*/read.
+   With Flexible orchestration the role actions Microsoft.Compute/virtualMachines/read and
+   Microsoft.Network/networkInterfaces/read are needed instead.
 
    It is recommended you make a dedicated key used only for auto-joining.
 `
@@ -70,8 +86,12 @@ func argsOrEnv(args map[string]string, key, env string) string {
 }
 
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
-	var authorizer autorest.Authorizer
+	return p.AddrsContext(context.Background(), args, l)
+}
 
+// AddrsContext looks up the addresses like Addrs and aborts the API calls
+// when ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
 	if args["provider"] != "azure" {
 		return nil, fmt.Errorf("discover-azure: invalid provider " + args["provider"])
 	}
@@ -80,26 +100,11 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 		l = log.New(ioutil.Discard, "", 0)
 	}
 
-	// check for environmental variables, and use if the argument hasn't been set in config
-	tenantID := argsOrEnv(args, "tenant_id", "ARM_TENANT_ID")
-	clientID := argsOrEnv(args, "client_id", "ARM_CLIENT_ID")
 	subscriptionID := argsOrEnv(args, "subscription_id", "ARM_SUBSCRIPTION_ID")
-	secretKey := argsOrEnv(args, "secret_access_key", "ARM_CLIENT_SECRET")
 
-	// Try to use the argument and environment provided arguments first, if this fails fall back to the Azure
-	// SDK provided methods
-	if tenantID != "" && clientID != "" && secretKey != "" {
-		var err error
-		authorizer, err = auth.NewClientCredentialsConfig(clientID, secretKey, tenantID).Authorizer()
-		if err != nil {
-			return nil, fmt.Errorf("discover-azure (ClientCredentials): %s", err)
-		}
-	} else {
-		var err error
-		authorizer, err = auth.NewAuthorizerFromEnvironment()
-		if err != nil {
-			return nil, fmt.Errorf("discover-azure (EnvironmentCredentials): %s", err)
-		}
+	authorizer, err := newAuthorizer(args, l)
+	if err != nil {
+		return nil, err
 	}
 
 	// Use tags if using network interfaces
@@ -109,6 +114,10 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 	// Use resourceGroup and vmScaleSet if using vm scale sets
 	resourceGroup := args["resource_group"]
 	vmScaleSet := args["vm_scale_set"]
+	orchestrationMode := args["orchestration_mode"]
+	if orchestrationMode != "" && orchestrationMode != "uniform" && orchestrationMode != "flexible" {
+		return nil, fmt.Errorf("discover-azure: invalid orchestration_mode %q", orchestrationMode)
+	}
 
 	// Setup the client using autorest; followed the structure from Terraform
 	vmnet := network.NewInterfacesClient(subscriptionID)
@@ -121,10 +130,20 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 
 	if tagName != "" && tagValue != "" && resourceGroup == "" && vmScaleSet == "" {
 		l.Printf("[DEBUG] discover-azure: using tag method. tag_name: %s, tag_value: %s", tagName, tagValue)
-		return fetchAddrsWithTags(tagName, tagValue, vmnet, l)
+		return fetchAddrsWithTags(ctx, tagName, tagValue, vmnet, l)
 	} else if resourceGroup != "" && vmScaleSet != "" && tagName == "" && tagValue == "" {
+		if orchestrationMode == "flexible" {
+			l.Printf("[DEBUG] discover-azure: using flexible vm scale set method. resource_group: %s, vm_scale_set: %s", resourceGroup, vmScaleSet)
+			vms := compute.NewVirtualMachinesClient(subscriptionID)
+			vms.Sender = vmnet.Sender
+			vms.Authorizer = authorizer
+			if p.userAgent != "" {
+				vms.Client.UserAgent = p.userAgent
+			}
+			return fetchAddrsWithFlexibleVmScaleSet(ctx, resourceGroup, vmScaleSet, vms, vmnet, l)
+		}
 		l.Printf("[DEBUG] discover-azure: using vm scale set method. resource_group: %s, vm_scale_set: %s", resourceGroup, vmScaleSet)
-		return fetchAddrsWithVmScaleSet(resourceGroup, vmScaleSet, vmnet, l)
+		return fetchAddrsWithVmScaleSet(ctx, resourceGroup, vmScaleSet, vmnet, l)
 	} else {
 		l.Printf("[ERROR] discover-azure: tag_name: %s, tag_value: %s", tagName, tagValue)
 		l.Printf("[ERROR] discover-azure: resource_group %s, vm_scale_set %s", resourceGroup, vmScaleSet)
@@ -133,11 +152,10 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 
 }
 
-func fetchAddrsWithTags(tagName string, tagValue string, vmnet network.InterfacesClient, l *log.Logger) ([]string, error) {
+func fetchAddrsWithTags(ctx context.Context, tagName string, tagValue string, vmnet network.InterfacesClient, l *log.Logger) ([]string, error) {
 	// Get all network interfaces across resource groups
 	// unless there is a compelling reason to restrict
 
-	ctx := context.Background()
 	netres, err := vmnet.ListAll(ctx)
 
 	if err != nil {
@@ -188,9 +206,8 @@ func fetchAddrsWithTags(tagName string, tagValue string, vmnet network.Interface
 	return addrs, nil
 }
 
-func fetchAddrsWithVmScaleSet(resourceGroup string, vmScaleSet string, vmnet network.InterfacesClient, l *log.Logger) ([]string, error) {
+func fetchAddrsWithVmScaleSet(ctx context.Context, resourceGroup string, vmScaleSet string, vmnet network.InterfacesClient, l *log.Logger) ([]string, error) {
 	// Get all network interfaces for a specific virtual machine scale set
-	ctx := context.Background()
 	netres, err := vmnet.ListVirtualMachineScaleSetNetworkInterfaces(ctx, resourceGroup, vmScaleSet)
 	if err != nil {
 		return nil, fmt.Errorf("discover-azure: %s", err)
@@ -226,3 +243,69 @@ func fetchAddrsWithVmScaleSet(resourceGroup string, vmScaleSet string, vmnet net
 	l.Printf("[DEBUG] discover-azure: Found ip addresses: %v", addrs)
 	return addrs, nil
 }
+
+func fetchAddrsWithFlexibleVmScaleSet(ctx context.Context, resourceGroup string, vmScaleSet string, vms compute.VirtualMachinesClient, vmnet network.InterfacesClient, l *log.Logger) ([]string, error) {
+	// Virtual machines of a scale set with Flexible orchestration are
+	// regular virtual machines which reference the scale set and have
+	// their own network interfaces.
+	suffix := strings.ToLower("/virtualMachineScaleSets/" + vmScaleSet)
+	vmIDs := map[string]bool{}
+	vmres, err := vms.ListComplete(ctx, resourceGroup)
+	if err != nil {
+		return nil, fmt.Errorf("discover-azure: %s", err)
+	}
+	for ; vmres.NotDone(); err = vmres.NextWithContext(ctx) {
+		if err != nil {
+			return nil, fmt.Errorf("discover-azure: %s", err)
+		}
+		vm := vmres.Value()
+		if vm.ID == nil || vm.VirtualMachineProperties == nil || vm.VirtualMachineScaleSet == nil || vm.VirtualMachineScaleSet.ID == nil {
+			continue
+		}
+		if !strings.HasSuffix(strings.ToLower(*vm.VirtualMachineScaleSet.ID), suffix) {
+			continue
+		}
+		l.Printf("[DEBUG] discover-azure: Virtual machine %s is part of %s", *vm.ID, vmScaleSet)
+		vmIDs[strings.ToLower(*vm.ID)] = true
+	}
+	if err != nil {
+		return nil, fmt.Errorf("discover-azure: %s", err)
+	}
+
+	netres, err := vmnet.ListComplete(ctx, resourceGroup)
+	if err != nil {
+		return nil, fmt.Errorf("discover-azure: %s", err)
+	}
+
+	var addrs []string
+	for ; netres.NotDone(); err = netres.NextWithContext(ctx) {
+		if err != nil {
+			return nil, fmt.Errorf("discover-azure: %s", err)
+		}
+		v := netres.Value()
+		if v.InterfacePropertiesFormat == nil || v.VirtualMachine == nil || v.VirtualMachine.ID == nil {
+			continue
+		}
+		if !vmIDs[strings.ToLower(*v.VirtualMachine.ID)] {
+			continue
+		}
+		if v.IPConfigurations == nil {
+			l.Printf("[DEBUG] discover-azure: Interface %s had no ip configuration", *v.ID)
+			continue
+		}
+		for _, x := range *v.IPConfigurations {
+			if x.InterfaceIPConfigurationPropertiesFormat == nil || x.PrivateIPAddress == nil {
+				l.Printf("[DEBUG] discover-azure: Interface %s had no private ip", *v.ID)
+				continue
+			}
+			iAddr := *x.PrivateIPAddress
+			l.Printf("[DEBUG] discover-azure: Interface %s has private ip: %s", *v.ID, iAddr)
+			addrs = append(addrs, iAddr)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("discover-azure: %s", err)
+	}
+	l.Printf("[DEBUG] discover-azure: Found ip addresses: %v", addrs)
+	return addrs, nil
+}
@@ -0,0 +1,101 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2015-06-15/network"
+)
+
+const testRG = "/subscriptions/sub/resourceGroups/rg/providers"
+
+func TestFetchAddrsWithFlexibleVmScaleSet(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case testRG + "/Microsoft.Compute/virtualMachines":
+			fmt.Fprintf(w, `{"value": [
+				{"id": "%[1]s/Microsoft.Compute/virtualMachines/consul-1", "properties": {"virtualMachineScaleSet": {"id": "%[1]s/Microsoft.Compute/virtualMachineScaleSets/consul"}}},
+				{"id": "%[1]s/Microsoft.Compute/virtualMachines/other-1", "properties": {"virtualMachineScaleSet": {"id": "%[1]s/Microsoft.Compute/virtualMachineScaleSets/other"}}},
+				{"id": "%[1]s/Microsoft.Compute/virtualMachines/single", "properties": {}}
+			], "nextLink": "http://%[2]s/page2"}`, testRG, r.Host)
+		case "/page2":
+			fmt.Fprintf(w, `{"value": [
+				{"id": "%[1]s/Microsoft.Compute/virtualMachines/consul-2", "properties": {"virtualMachineScaleSet": {"id": "%[1]s/microsoft.compute/virtualmachinescalesets/CONSUL"}}}
+			]}`, testRG)
+		case testRG + "/Microsoft.Network/networkInterfaces":
+			fmt.Fprintf(w, `{"value": [
+				{"id": "%[1]s/Microsoft.Network/networkInterfaces/consul-1-nic", "properties": {"virtualMachine": {"id": "%[1]s/Microsoft.Compute/virtualMachines/consul-1"}, "ipConfigurations": [{"properties": {"privateIPAddress": "10.0.0.1"}}]}},
+				{"id": "%[1]s/Microsoft.Network/networkInterfaces/other-1-nic", "properties": {"virtualMachine": {"id": "%[1]s/Microsoft.Compute/virtualMachines/other-1"}, "ipConfigurations": [{"properties": {"privateIPAddress": "10.0.1.1"}}]}},
+				{"id": "%[1]s/Microsoft.Network/networkInterfaces/consul-2-nic", "properties": {"virtualMachine": {"id": "%[1]s/Microsoft.Compute/virtualMachines/consul-2"}, "ipConfigurations": [{"properties": {"privateIPAddress": "10.0.0.2"}}]}},
+				{"id": "%[1]s/Microsoft.Network/networkInterfaces/unattached", "properties": {"ipConfigurations": [{"properties": {"privateIPAddress": "10.0.2.1"}}]}}
+			]}`, testRG)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	vms := compute.NewVirtualMachinesClientWithBaseURI(ts.URL, "sub")
+	vmnet := network.NewInterfacesClientWithBaseURI(ts.URL, "sub")
+	l := log.New(os.Stderr, "", log.LstdFlags)
+	addrs, err := fetchAddrsWithFlexibleVmScaleSet(context.Background(), "rg", "consul", vms, vmnet, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1", "10.0.0.2"}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v want %v", addrs, want)
+	}
+}
+
+func TestFederatedTokenSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discover-azure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(file, []byte("header.payload.signature\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v := url.Values{}
+	s := &federatedTokenSecret{file: file}
+	if err := s.SetAuthenticationValues(nil, &v); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := v.Get("client_assertion"), "header.payload.signature"; got != want {
+		t.Fatalf("got client_assertion %q want %q", got, want)
+	}
+	if got, want := v.Get("client_assertion_type"), "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"; got != want {
+		t.Fatalf("got client_assertion_type %q want %q", got, want)
+	}
+
+	s = &federatedTokenSecret{file: filepath.Join(dir, "missing")}
+	if err := s.SetAuthenticationValues(nil, &v); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestNewAuthorizerInvalid(t *testing.T) {
+	l := log.New(ioutil.Discard, "", 0)
+	tests := []map[string]string{
+		{"auth_type": "password"},
+		{"auth_type": "workload_identity", "tenant_id": "tenant", "client_id": "client", "federated_token_file": ""},
+	}
+	for _, args := range tests {
+		if _, err := newAuthorizer(args, l); err == nil {
+			t.Fatalf("%v: expected error", args)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package provider
+
+import "context"
+
+// LookupContext calls lookup in a new goroutine and returns its result or
+// the error of ctx if ctx is done first. It lets providers whose SDK does
+// not accept a context return once ctx is done. The abandoned lookup is not
+// aborted but keeps running until it returns on its own.
+func LookupContext(ctx context.Context, lookup func() ([]string, error)) ([]string, error) {
+	if ctx.Done() == nil {
+		return lookup()
+	}
+
+	type result struct {
+		addrs []string
+		err   error
+	}
+	// buffered so that an abandoned lookup does not block forever
+	ch := make(chan result, 1)
+	go func() {
+		addrs, err := lookup()
+		ch <- result{addrs, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.addrs, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
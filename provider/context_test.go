@@ -0,0 +1,39 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLookupContext(t *testing.T) {
+	addrs, err := LookupContext(context.Background(), func() ([]string, error) {
+		return []string{"10.0.0.1"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1"}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v want %v", addrs, want)
+	}
+
+	want := errors.New("lookup failed")
+	if _, err := LookupContext(context.Background(), func() ([]string, error) { return nil, want }); err != want {
+		t.Fatalf("got error %v want %v", err, want)
+	}
+
+	// a blocked lookup is abandoned once ctx is done
+	block := make(chan struct{})
+	defer close(block)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = LookupContext(ctx, func() ([]string, error) {
+		<-block
+		return []string{"10.0.0.1"}, nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got error %v want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -40,7 +40,7 @@ func (t *TokenSource) Token() (*oauth2.Token, error) {
 	return token, nil
 }
 
-func listDropletsByTag(c *godo.Client, tagName string) ([]godo.Droplet, error) {
+func listDropletsByTag(ctx context.Context, c *godo.Client, tagName string) ([]godo.Droplet, error) {
 	dropletList := []godo.Droplet{}
 	pageOpt := &godo.ListOptions{
 		Page:    1,
@@ -48,7 +48,7 @@ func listDropletsByTag(c *godo.Client, tagName string) ([]godo.Droplet, error) {
 	}
 
 	for {
-		droplets, resp, err := c.Droplets.ListByTag(context.TODO(), tagName, pageOpt)
+		droplets, resp, err := c.Droplets.ListByTag(ctx, tagName, pageOpt)
 		if err != nil {
 			return nil, err
 		}
@@ -73,6 +73,12 @@ func listDropletsByTag(c *godo.Client, tagName string) ([]godo.Droplet, error) {
 }
 
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and aborts the API calls
+// when ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
 	if args["provider"] != "digitalocean" {
 		return nil, fmt.Errorf("discover-digitalocean: invalid provider " + args["provider"])
 	}
@@ -96,7 +102,7 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 		client.UserAgent = p.userAgent
 	}
 
-	droplets, err := listDropletsByTag(client, tagName)
+	droplets, err := listDropletsByTag(ctx, client, tagName)
 	if err != nil {
 		return nil, fmt.Errorf("discover-digitalocean: %s", err)
 	}
@@ -0,0 +1,168 @@
+// Package dns provides node discovery with DNS lookups.
+package dns
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTimeout is the default timeout of the lookup.
+const defaultTimeout = 5 * time.Second
+
+// resolver looks up DNS records. It is implemented by *net.Resolver.
+type resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// newResolver returns the resolver for the DNS server or the system
+// resolver if server is empty.
+var newResolver = func(server string) resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+type Provider struct{}
+
+func (p *Provider) Help() string {
+	return `DNS:
+
+    provider: "dns"
+    name:     The name to look up, e.g. "consul.service.example.com" or
+              "_serf._tcp.consul.example.com" for SRV records.
+    type:     "a", "aaaa", "ip" for both or "srv". (default: "a")
+    server:   The address of the DNS server as host:port, e.g.
+              server=10.0.0.2:5353. Without a port the server is queried on
+              port 53, e.g. server=10.0.0.2. (default: the system resolver)
+    timeout:  The timeout of the lookup (eg. "2s"). (default: "5s")
+
+    For SRV records the target names are returned together with the
+    port of the record, e.g. "consul-1.example.com:8301". The port of the
+    DNS server must be set in "server" and not with the "port" option,
+    which appends a port to all returned addresses.
+`
+}
+
+func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// config is the parsed configuration of a lookup.
+type config struct {
+	name    string
+	typ     string
+	server  string
+	timeout time.Duration
+}
+
+// parseConfig parses and validates the arguments of a lookup.
+func parseConfig(args map[string]string) (*config, error) {
+	if args["provider"] != "dns" {
+		return nil, fmt.Errorf("discover-dns: invalid provider " + args["provider"])
+	}
+
+	c := &config{
+		name:    args["name"],
+		typ:     strings.ToLower(args["type"]),
+		server:  args["server"],
+		timeout: defaultTimeout,
+	}
+	if c.name == "" {
+		return nil, fmt.Errorf("discover-dns: no name specified")
+	}
+
+	switch c.typ {
+	case "":
+		c.typ = "a"
+	case "a", "aaaa", "ip", "srv":
+	default:
+		return nil, fmt.Errorf("discover-dns: invalid type %q", args["type"])
+	}
+
+	if c.server != "" {
+		if _, _, err := net.SplitHostPort(c.server); err != nil {
+			c.server = net.JoinHostPort(strings.Trim(c.server, "[]"), "53")
+		}
+		host, port, err := net.SplitHostPort(c.server)
+		if n, perr := strconv.Atoi(port); err != nil || perr != nil || host == "" || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("discover-dns: invalid server %q: must be host:port, e.g. 10.0.0.2:5353, or a host for port 53", args["server"])
+		}
+	}
+
+	if v := args["timeout"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("discover-dns: invalid timeout %q", v)
+		}
+		c.timeout = d
+	}
+	return c, nil
+}
+
+// Ping validates the arguments without any lookups.
+func (p *Provider) Ping(ctx context.Context, args map[string]string, l *log.Logger) error {
+	_, err := parseConfig(args)
+	return err
+}
+
+// AddrsContext looks up the addresses like Addrs and aborts the lookup when
+// ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
+	c, err := parseConfig(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if l == nil {
+		l = log.New(ioutil.Discard, "", 0)
+	}
+
+	name, typ, server := c.name, c.typ, c.server
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	l.Printf("[DEBUG] discover-dns: Looking up %s records of %s using server %q", strings.ToUpper(typ), name, server)
+	r := newResolver(server)
+
+	var addrs []string
+	if typ == "srv" {
+		_, srvs, err := r.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, fmt.Errorf("discover-dns: %s", err)
+		}
+		for _, srv := range srvs {
+			target := strings.TrimSuffix(srv.Target, ".")
+			addrs = append(addrs, net.JoinHostPort(target, strconv.Itoa(int(srv.Port))))
+		}
+	} else {
+		ips, err := r.LookupIPAddr(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("discover-dns: %s", err)
+		}
+		for _, ip := range ips {
+			isV4 := ip.IP.To4() != nil
+			if (typ == "a" && !isV4) || (typ == "aaaa" && isV4) {
+				continue
+			}
+			addrs = append(addrs, ip.IP.String())
+		}
+	}
+
+	l.Printf("[DEBUG] discover-dns: Found %d addresses", len(addrs))
+	return addrs, nil
+}
@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// fakeResolver returns canned records for the names used in the tests.
+type fakeResolver struct {
+	srvs []*net.SRV
+	ips  []net.IPAddr
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if name != "_serf._tcp.consul.example.com" {
+		return "", nil, errors.New("no such host")
+	}
+	return name, r.srvs, nil
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if host != "consul.example.com" {
+		return nil, errors.New("no such host")
+	}
+	return r.ips, nil
+}
+
+func TestAddrsFake(t *testing.T) {
+	fake := &fakeResolver{
+		srvs: []*net.SRV{
+			{Target: "consul-1.example.com.", Port: 8301},
+			{Target: "consul-2.example.com.", Port: 8302},
+		},
+		ips: []net.IPAddr{
+			{IP: net.ParseIP("10.0.0.1")},
+			{IP: net.ParseIP("fd00::1")},
+			{IP: net.ParseIP("10.0.0.2")},
+		},
+	}
+	var server string
+	defer func(f func(string) resolver) { newResolver = f }(newResolver)
+	newResolver = func(s string) resolver {
+		server = s
+		return fake
+	}
+
+	tests := []struct {
+		name   string
+		args   map[string]string
+		addrs  []string
+		server string
+		err    bool
+	}{
+		{"a", map[string]string{}, []string{"10.0.0.1", "10.0.0.2"}, "", false},
+		{"aaaa", map[string]string{"type": "AAAA"}, []string{"fd00::1"}, "", false},
+		{"ip", map[string]string{"type": "ip"}, []string{"10.0.0.1", "fd00::1", "10.0.0.2"}, "", false},
+		{"srv", map[string]string{"type": "srv", "name": "_serf._tcp.consul.example.com"}, []string{"consul-1.example.com:8301", "consul-2.example.com:8302"}, "", false},
+		{"server", map[string]string{"server": "10.0.0.53"}, []string{"10.0.0.1", "10.0.0.2"}, "10.0.0.53:53", false},
+		{"server with port", map[string]string{"server": "[fd00::53]:5353"}, []string{"10.0.0.1", "10.0.0.2"}, "[fd00::53]:5353", false},
+		{"ipv6 server", map[string]string{"server": "fd00::53"}, []string{"10.0.0.1", "10.0.0.2"}, "[fd00::53]:53", false},
+		{"unknown name", map[string]string{"name": "vault.example.com"}, nil, "", true},
+		{"invalid type", map[string]string{"type": "mx"}, nil, "", true},
+		{"invalid timeout", map[string]string{"timeout": "soon"}, nil, "", true},
+	}
+
+	p := &Provider{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server = ""
+			args := map[string]string{"provider": "dns", "name": "consul.example.com"}
+			for k, v := range tt.args {
+				args[k] = v
+			}
+			addrs, err := p.Addrs(args, nil)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(addrs, tt.addrs) {
+				t.Fatalf("got %v want %v", addrs, tt.addrs)
+			}
+			if server != tt.server {
+				t.Fatalf("got server %q want %q", server, tt.server)
+			}
+		})
+	}
+}
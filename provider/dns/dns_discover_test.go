@@ -0,0 +1,52 @@
+package dns_test
+
+import (
+	"context"
+	"log"
+	"os"
+	"testing"
+
+	discover "github.com/hashicorp/go-discover"
+	"github.com/hashicorp/go-discover/provider/dns"
+)
+
+var _ discover.Provider = (*dns.Provider)(nil)
+var _ discover.ProviderWithContext = (*dns.Provider)(nil)
+var _ discover.ProviderWithPing = (*dns.Provider)(nil)
+
+func TestAddrsLocalhost(t *testing.T) {
+	p := &dns.Provider{}
+	l := log.New(os.Stderr, "", log.LstdFlags)
+	addrs, err := p.Addrs(discover.Config{"provider": "dns", "name": "localhost"}, l)
+	if err != nil {
+		t.Skipf("cannot resolve localhost: %s", err)
+	}
+	for _, addr := range addrs {
+		if addr != "127.0.0.1" {
+			t.Fatalf("bad: %v", addrs)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	p := &dns.Provider{}
+	l := log.New(os.Stderr, "", log.LstdFlags)
+	if err := p.Ping(context.Background(), map[string]string{"provider": "dns", "name": "consul.example.com", "server": "10.0.0.53"}, l); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []map[string]string{
+		{},
+		{"name": "consul.example.com", "type": "mx"},
+		{"name": "consul.example.com", "server": "10.0.0.53:dns"},
+		{"name": "consul.example.com", "server": "10.0.0.53:0"},
+		{"name": "consul.example.com", "server": ":5353"},
+		{"name": "consul.example.com", "timeout": "soon"},
+	}
+	for _, args := range tests {
+		args["provider"] = "dns"
+		if err := p.Ping(context.Background(), args, l); err == nil {
+			t.Fatalf("%v: expected error", args)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Package exec provides node discovery with an external command.
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-discover/provider"
+)
+
+// defaultTimeout is the default time the command may run.
+const defaultTimeout = 30 * time.Second
+
+// Request is written as JSON to the standard input of the command.
+type Request struct {
+	// Args contains all key/value pairs of the config string, including
+	// provider and command.
+	Args map[string]string `json:"args"`
+}
+
+// Response is read as JSON from the standard output of the command.
+type Response struct {
+	// Addrs contains the discovered addresses. It is ignored if Nodes is
+	// set.
+	Addrs []string `json:"addrs,omitempty"`
+
+	// Nodes contains the discovered nodes together with their metadata.
+	Nodes []Node `json:"nodes,omitempty"`
+
+	// Error reports a failed lookup. A command may also exit with a non-zero
+	// status instead.
+	Error string `json:"error,omitempty"`
+}
+
+// Node is a discovered node in the Response.
+type Node struct {
+	Addr string            `json:"addr"`
+	Name string            `json:"name,omitempty"`
+	ID   string            `json:"id,omitempty"`
+	Zone string            `json:"zone,omitempty"`
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+type Provider struct{}
+
+func (p *Provider) Help() string {
+	return `Exec:
+
+    provider: "exec"
+    command:  The path of the command which discovers the nodes.
+    timeout:  The time the command may run (eg. "10s"). (default: "30s")
+
+    The command is run without arguments and receives all options of the
+    config string as JSON on its standard input:
+
+      {"args": {"provider": "exec", "command": "/usr/local/bin/discover-foo", "key": "value"}}
+
+    It writes the discovered addresses or nodes as JSON to its standard
+    output:
+
+      {"addrs": ["10.0.0.1", "10.0.0.2"]}
+      {"nodes": [{"addr": "10.0.0.1", "name": "foo-1", "id": "1", "zone": "a", "meta": {"key": "value"}}]}
+
+    A failed lookup is reported with {"error": "..."} or a non-zero exit
+    status. Every line the command writes to its standard error is logged.
+`
+}
+
+func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and kills the command when
+// ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
+	nodes, err := p.Nodes(ctx, args, l)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Addrs(nodes), nil
+}
+
+// Nodes runs the command and returns the nodes it discovered. Commands which
+// only return addresses yield nodes without metadata.
+func (p *Provider) Nodes(ctx context.Context, args map[string]string, l *log.Logger) ([]provider.Node, error) {
+	if args["provider"] != "exec" {
+		return nil, fmt.Errorf("discover-exec: invalid provider %s", args["provider"])
+	}
+
+	if l == nil {
+		l = log.New(ioutil.Discard, "", 0)
+	}
+
+	command := args["command"]
+	if command == "" {
+		return nil, fmt.Errorf("discover-exec: no command specified")
+	}
+
+	timeout := defaultTimeout
+	if v := args["timeout"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("discover-exec: invalid timeout %q", v)
+		}
+		timeout = d
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := json.Marshal(Request{Args: args})
+	if err != nil {
+		return nil, fmt.Errorf("discover-exec: %s", err)
+	}
+
+	l.Printf("[DEBUG] discover-exec: Running %s", command)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command)
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+
+	logLines(l, &stderr)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("discover-exec: %s timed out after %s", command, timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("discover-exec: %s failed: %s", command, err)
+	}
+
+	var resp Response
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("discover-exec: %s returned invalid JSON: %s", command, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("discover-exec: %s failed: %s", command, resp.Error)
+	}
+
+	var nodes []provider.Node
+	if resp.Nodes != nil {
+		for _, n := range resp.Nodes {
+			if n.Addr == "" {
+				l.Printf("[DEBUG] discover-exec: ignoring node %q without address", n.Name)
+				continue
+			}
+			nodes = append(nodes, provider.Node{Addr: n.Addr, Name: n.Name, ID: n.ID, Zone: n.Zone, Meta: n.Meta})
+		}
+	} else {
+		for _, addr := range resp.Addrs {
+			nodes = append(nodes, provider.Node{Addr: addr})
+		}
+	}
+
+	l.Printf("[DEBUG] discover-exec: %s found %d nodes", command, len(nodes))
+	return nodes, nil
+}
+
+// logLines logs every non-empty line written by the command to its standard
+// error. Lines without a log level are logged at INFO.
+func logLines(l *log.Logger, stderr *bytes.Buffer) {
+	s := bufio.NewScanner(stderr)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "["):
+			l.Printf("%s", line)
+		default:
+			l.Printf("[INFO] discover-exec: %s", line)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package exec_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	discover "github.com/hashicorp/go-discover"
+	"github.com/hashicorp/go-discover/provider/exec"
+)
+
+var _ discover.Provider = (*exec.Provider)(nil)
+var _ discover.NodeProvider = (*exec.Provider)(nil)
+
+// TestMain lets the test binary act as the command when EXEC_TEST_PLUGIN is
+// set. The mode key of the request selects the behavior.
+func TestMain(m *testing.M) {
+	if os.Getenv("EXEC_TEST_PLUGIN") != "1" {
+		os.Exit(m.Run())
+	}
+
+	var req exec.Request
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	switch req.Args["mode"] {
+	case "addrs":
+		fmt.Fprintln(os.Stderr, "[DEBUG] looking up")
+		json.NewEncoder(os.Stdout).Encode(exec.Response{Addrs: []string{"10.0.0.1", req.Args["addr"]}})
+	case "nodes":
+		json.NewEncoder(os.Stdout).Encode(exec.Response{Nodes: []exec.Node{
+			{Addr: "10.0.0.1", Name: "node-1", ID: "1", Zone: "a", Meta: map[string]string{"role": "server"}},
+			{Name: "no-addr"},
+		}})
+	case "error":
+		json.NewEncoder(os.Stdout).Encode(exec.Response{Error: "access denied"})
+	case "exit":
+		fmt.Fprintln(os.Stderr, "crashed")
+		os.Exit(1)
+	case "invalid":
+		fmt.Println("10.0.0.1")
+	case "sleep":
+		time.Sleep(10 * time.Second)
+	}
+	os.Exit(0)
+}
+
+func TestNodes(t *testing.T) {
+	os.Setenv("EXEC_TEST_PLUGIN", "1")
+	defer os.Unsetenv("EXEC_TEST_PLUGIN")
+
+	tests := []struct {
+		mode  string
+		args  map[string]string
+		nodes []discover.Node
+		err   string
+	}{
+		{"addrs", map[string]string{"addr": "10.0.0.2"}, []discover.Node{{Addr: "10.0.0.1"}, {Addr: "10.0.0.2"}}, ""},
+		{"nodes", nil, []discover.Node{{Addr: "10.0.0.1", Name: "node-1", ID: "1", Zone: "a", Meta: map[string]string{"role": "server"}}}, ""},
+		{"error", nil, nil, "failed: access denied"},
+		{"exit", nil, nil, "failed: exit status 1"},
+		{"invalid", nil, nil, "returned invalid JSON"},
+		{"sleep", map[string]string{"timeout": "100ms"}, nil, "timed out after 100ms"},
+	}
+
+	p := &exec.Provider{}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			args := map[string]string{"provider": "exec", "command": os.Args[0], "mode": tt.mode}
+			for k, v := range tt.args {
+				args[k] = v
+			}
+			nodes, err := p.Nodes(context.Background(), args, log.New(ioutil.Discard, "", 0))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(nodes, tt.nodes) {
+				t.Fatalf("got %+v want %+v", nodes, tt.nodes)
+			}
+		})
+	}
+}
+
+func TestAddrsLogsStderr(t *testing.T) {
+	os.Setenv("EXEC_TEST_PLUGIN", "1")
+	defer os.Unsetenv("EXEC_TEST_PLUGIN")
+
+	var buf bytes.Buffer
+	p := &exec.Provider{}
+	args := map[string]string{"provider": "exec", "command": os.Args[0], "mode": "exit"}
+	if _, err := p.Addrs(args, log.New(&buf, "", 0)); err == nil {
+		t.Fatal("expected error")
+	}
+	if got, want := buf.String(), "[INFO] discover-exec: crashed\n"; !strings.Contains(got, want) {
+		t.Fatalf("got log %q want it to contain %q", got, want)
+	}
+}
+
+func TestInvalidArgs(t *testing.T) {
+	p := &exec.Provider{}
+	l := log.New(ioutil.Discard, "", 0)
+	for _, args := range []map[string]string{
+		{"provider": "exec"},
+		{"provider": "exec", "command": "true", "timeout": "soon"},
+	} {
+		if _, err := p.Addrs(args, l); err == nil {
+			t.Fatalf("expected error for %v", args)
+		}
+	}
+}
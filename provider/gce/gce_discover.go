@@ -2,14 +2,20 @@
 package gce
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"path"
+	"strings"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 )
 
 type Provider struct {
@@ -27,6 +33,14 @@ func (p *Provider) Help() string {
     project_name:     The name of the project. discovered if not set
     tag_value:        The tag value for filtering instances
     zone_pattern:     A RE2 regular expression for filtering zones, e.g. us-west1-.*, or us-(?west|east).*
+    zones:            A comma separated list of zones and regions, e.g. us-west1,us-east1-b.
+                      Regions include all of their zones. Unknown names are an error.
+                      Cannot be used with zone_pattern
+    mig_name:         The name of a zonal or regional managed instance group. Only the
+                      instances of the group are returned. tag_value is optional then
+    address_type:     "private_v4" or "alias_v4" for the alias IP addresses of the
+                      instance. Only alias ranges with a single address (/32) are
+                      returned, wider ranges are skipped. (default: "private_v4")
     credentials_file: The path to the credentials file. See below for more details
 
     The credentials for a GCE Service Account are required and are searched in
@@ -43,6 +57,12 @@ func (p *Provider) Help() string {
 }
 
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and aborts the API calls
+// when ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
 	if args["provider"] != "gce" {
 		return nil, fmt.Errorf("discover-gce: invalid provider " + args["provider"])
 	}
@@ -55,6 +75,20 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 	zone := args["zone_pattern"]
 	creds := args["credentials_file"]
 	tagValue := args["tag_value"]
+	zoneNames := args["zones"]
+	migName := args["mig_name"]
+	addrType := args["address_type"]
+
+	if zone != "" && zoneNames != "" {
+		return nil, fmt.Errorf("discover-gce: zone_pattern and zones cannot be used together")
+	}
+	switch addrType {
+	case "":
+		addrType = "private_v4"
+	case "private_v4", "alias_v4":
+	default:
+		return nil, fmt.Errorf("discover-gce: invalid address_type %q", addrType)
+	}
 
 	// determine the project name
 	if project == "" {
@@ -83,22 +117,41 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 		svc.UserAgent = p.userAgent
 	}
 
+	// lookup the instances of the managed instance group
+	var instances map[string]bool
+	if migName != "" {
+		l.Printf("[INFO] discover-gce: Looking up instances of managed instance group %s", migName)
+		instances, err = lookupMIGInstances(ctx, svc, client, project, migName)
+		if err != nil {
+			return nil, fmt.Errorf("discover-gce: %s", err)
+		}
+		l.Printf("[INFO] discover-gce: Managed instance group %s has %d instances", migName, len(instances))
+	}
+
 	// lookup the project zones to look in
-	if zone != "" {
+	var names []string
+	switch {
+	case zone != "":
 		l.Printf("[INFO] discover-gce: Looking up zones matching %s", zone)
-	} else {
+	case zoneNames != "":
+		names = strings.Split(zoneNames, ",")
+		l.Printf("[INFO] discover-gce: Looking up zones in %v", names)
+	default:
 		l.Printf("[INFO] discover-gce: Looking up all zones")
 	}
-	zones, err := lookupZones(svc, project, zone)
+	zones, err := lookupZones(ctx, svc, project, zone, names)
 	if err != nil {
 		return nil, fmt.Errorf("discover-gce: %s", err)
 	}
+	if instances != nil {
+		zones = instanceZones(zones, instances)
+	}
 	l.Printf("[INFO] discover-gce: Found zones %v", zones)
 
 	// lookup the instance addresses
 	var addrs []string
 	for _, zone := range zones {
-		a, err := lookupAddrs(svc, project, zone, tagValue)
+		a, err := lookupAddrs(ctx, svc, project, zone, tagValue, instances, addrType)
 		if err != nil {
 			return nil, fmt.Errorf("discover-gce: %s", err)
 		}
@@ -152,40 +205,175 @@ func lookupProject() (string, error) {
 	return string(project), nil
 }
 
-// lookupZones retrieves the zones of the project and filters them by pattern.
-func lookupZones(svc *compute.Service, project, pattern string) ([]string, error) {
+// lookupZones retrieves the zones of the project and filters them by pattern
+// or, if names is not empty, by the zone and region names.
+func lookupZones(ctx context.Context, svc *compute.Service, project, pattern string, names []string) ([]string, error) {
 	call := svc.Zones.List(project)
 	if pattern != "" {
 		call = call.Filter("name eq " + pattern)
 	}
 
+	want := map[string]bool{}
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			want[name] = true
+		}
+	}
+
 	var zones []string
+	found := map[string]bool{}
 	f := func(page *compute.ZoneList) error {
 		for _, v := range page.Items {
+			region := path.Base(v.Region)
+			if len(want) > 0 && !want[v.Name] && !want[region] {
+				continue
+			}
+			found[v.Name], found[region] = true, true
 			zones = append(zones, v.Name)
 		}
 		return nil
 	}
 
-	if err := call.Pages(oauth2.NoContext, f); err != nil {
+	if err := call.Pages(ctx, f); err != nil {
 		return nil, err
 	}
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" && !found[name] {
+			return nil, fmt.Errorf("unknown zone or region %q", name)
+		}
+	}
 	return zones, nil
 }
 
-// lookupAddrs retrieves the private ip addresses of all instances in a given
-// project and zone which have a matching tag value.
-func lookupAddrs(svc *compute.Service, project, zone, tag string) ([]string, error) {
+// lookupMIGInstances returns the URLs of the instances of all zonal and
+// regional managed instance groups with the given name.
+func lookupMIGInstances(ctx context.Context, svc *compute.Service, client *http.Client, project, name string) (map[string]bool, error) {
+	var migs []*compute.InstanceGroupManager
+	f := func(page *compute.InstanceGroupManagerAggregatedList) error {
+		for _, scope := range page.Items {
+			migs = append(migs, scope.InstanceGroupManagers...)
+		}
+		return nil
+	}
+	call := svc.InstanceGroupManagers.AggregatedList(project).Filter("name eq " + name)
+	if err := call.Pages(ctx, f); err != nil {
+		return nil, err
+	}
+	if len(migs) == 0 {
+		return nil, fmt.Errorf("managed instance group %q not found", name)
+	}
+
+	instances := map[string]bool{}
+	for _, mig := range migs {
+		scope := "zones/" + path.Base(mig.Zone)
+		if mig.Zone == "" {
+			scope = "regions/" + path.Base(mig.Region)
+		}
+		u := googleapi.ResolveRelative(svc.BasePath, project+"/"+scope+"/instanceGroupManagers/"+mig.Name+"/listManagedInstances")
+		var token string
+		for {
+			page, err := listManagedInstances(ctx, client, svc.UserAgent, u, token)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range page.ManagedInstances {
+				instances[v.Instance] = true
+			}
+			if token = page.NextPageToken; token == "" {
+				break
+			}
+		}
+	}
+	return instances, nil
+}
+
+// managedInstancesPage is a page of the listManagedInstances response.
+// The response types of the compute package do not have the
+// nextPageToken of the API.
+type managedInstancesPage struct {
+	ManagedInstances []*compute.ManagedInstance `json:"managedInstances"`
+	NextPageToken    string                     `json:"nextPageToken"`
+}
+
+// listManagedInstances fetches the page of managed instances at u which
+// starts at token.
+func listManagedInstances(ctx context.Context, client *http.Client, userAgent, u, token string) (*managedInstancesPage, error) {
+	q := url.Values{"alt": {"json"}}
+	if token != "" {
+		q.Set("pageToken", token)
+	}
+	req, err := http.NewRequest("POST", u+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var page managedInstancesPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// instanceZones returns the zones which contain at least one of the
+// instances. The instances are given by their URL which contains the zone.
+func instanceZones(zones []string, instances map[string]bool) []string {
+	have := map[string]bool{}
+	for u := range instances {
+		have[path.Base(path.Dir(path.Dir(u)))] = true
+	}
+	var found []string
+	for _, zone := range zones {
+		if have[zone] {
+			found = append(found, zone)
+		}
+	}
+	return found
+}
+
+// lookupAddrs retrieves the addresses of the first network interface of all
+// instances in a given project and zone which have a matching tag value. If
+// instances is not nil only these instances are returned and the tag value is
+// optional.
+func lookupAddrs(ctx context.Context, svc *compute.Service, project, zone, tag string, instances map[string]bool, addrType string) ([]string, error) {
 	var addrs []string
 	f := func(page *compute.InstanceList) error {
 		for _, v := range page.Items {
-			if len(v.NetworkInterfaces) == 0 || v.NetworkInterfaces[0].NetworkIP == "" {
+			if len(v.NetworkInterfaces) == 0 {
+				continue
+			}
+			if instances != nil && !instances[v.SelfLink] {
 				continue
 			}
-			for _, t := range v.Tags.Items {
-				if t == tag {
-					addrs = append(addrs, v.NetworkInterfaces[0].NetworkIP)
-					break
+			if (tag != "" || instances == nil) && !hasTag(v, tag) {
+				continue
+			}
+			nic := v.NetworkInterfaces[0]
+			switch addrType {
+			case "alias_v4":
+				// Only single address ranges are addresses of the
+				// instance, wider ranges are e.g. for its pods.
+				for _, r := range nic.AliasIpRanges {
+					addr := strings.TrimSuffix(r.IpCidrRange, "/32")
+					if !strings.Contains(addr, "/") {
+						addrs = append(addrs, addr)
+					}
+				}
+			default:
+				if nic.NetworkIP != "" {
+					addrs = append(addrs, nic.NetworkIP)
 				}
 			}
 		}
@@ -193,8 +381,20 @@ func lookupAddrs(svc *compute.Service, project, zone, tag string) ([]string, err
 	}
 
 	call := svc.Instances.List(project, zone)
-	if err := call.Pages(oauth2.NoContext, f); err != nil {
+	if err := call.Pages(ctx, f); err != nil {
 		return nil, err
 	}
 	return addrs, nil
 }
+
+func hasTag(v *compute.Instance, tag string) bool {
+	if v.Tags == nil {
+		return false
+	}
+	for _, t := range v.Tags.Items {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
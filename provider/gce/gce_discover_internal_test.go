@@ -0,0 +1,154 @@
+package gce
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+func testService(t *testing.T) (*compute.Service, *http.Client, func()) {
+	const base = "https://www.googleapis.com/compute/v1/projects/p"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/projects/p/zones":
+			fmt.Fprintf(w, `{"items": [
+				{"name": "us-west1-a", "region": "%[1]s/regions/us-west1"},
+				{"name": "us-west1-b", "region": "%[1]s/regions/us-west1"},
+				{"name": "us-east1-b", "region": "%[1]s/regions/us-east1"},
+				{"name": "us-east1-c", "region": "%[1]s/regions/us-east1"}
+			]}`, base)
+		case "/projects/p/aggregated/instanceGroupManagers":
+			if r.URL.Query().Get("filter") != "name eq consul" {
+				fmt.Fprint(w, `{"items": {}}`)
+				return
+			}
+			fmt.Fprintf(w, `{"items": {
+				"regions/us-west1": {"instanceGroupManagers": [{"name": "consul", "region": "%[1]s/regions/us-west1"}]},
+				"zones/us-east1-b": {"instanceGroupManagers": [{"name": "consul", "zone": "%[1]s/zones/us-east1-b"}]}
+			}}`, base)
+		case "/projects/p/regions/us-west1/instanceGroupManagers/consul/listManagedInstances":
+			if r.Method != "POST" {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if r.URL.Query().Get("pageToken") == "" {
+				fmt.Fprintf(w, `{"managedInstances": [{"instance": "%s/zones/us-west1-a/instances/consul-1"}], "nextPageToken": "2"}`, base)
+				return
+			}
+			fmt.Fprintf(w, `{"managedInstances": [{"instance": "%s/zones/us-west1-b/instances/consul-3"}]}`, base)
+		case "/projects/p/zones/us-east1-b/instanceGroupManagers/consul/listManagedInstances":
+			fmt.Fprintf(w, `{"managedInstances": [{"instance": "%s/zones/us-east1-b/instances/consul-2"}]}`, base)
+		case "/projects/p/zones/us-west1-a/instances":
+			fmt.Fprintf(w, `{"items": [
+				{"selfLink": "%[1]s/zones/us-west1-a/instances/consul-1", "networkInterfaces": [{"networkIP": "10.0.0.1", "aliasIpRanges": [{"ipCidrRange": "10.1.0.1/32"}, {"ipCidrRange": "10.2.0.0/24"}, {"ipCidrRange": "10.3.0.1"}]}]},
+				{"selfLink": "%[1]s/zones/us-west1-a/instances/tagged", "tags": {"items": ["consul"]}, "networkInterfaces": [{"networkIP": "10.0.0.3"}]},
+				{"selfLink": "%[1]s/zones/us-west1-a/instances/no-nic"}
+			]}`, base)
+		case "/projects/p/zones/us-east1-b/instances":
+			fmt.Fprintf(w, `{"items": [
+				{"selfLink": "%[1]s/zones/us-east1-b/instances/consul-2", "tags": {"items": ["consul"]}, "networkInterfaces": [{"networkIP": "10.0.0.2"}]}
+			]}`, base)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	svc, err := compute.New(ts.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.BasePath = ts.URL + "/projects/"
+	return svc, ts.Client(), ts.Close
+}
+
+func TestLookupZones(t *testing.T) {
+	svc, _, done := testService(t)
+	defer done()
+
+	tests := []struct {
+		names []string
+		zones []string
+	}{
+		{nil, []string{"us-west1-a", "us-west1-b", "us-east1-b", "us-east1-c"}},
+		{[]string{"us-west1"}, []string{"us-west1-a", "us-west1-b"}},
+		{[]string{"us-west1-b", " us-east1"}, []string{"us-west1-b", "us-east1-b", "us-east1-c"}},
+	}
+	for _, tt := range tests {
+		zones, err := lookupZones(context.Background(), svc, "p", "", tt.names)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(zones, tt.zones) {
+			t.Fatalf("%v: got %v want %v", tt.names, zones, tt.zones)
+		}
+	}
+
+	for _, names := range [][]string{{"eu-west1"}, {"us-west1", "us-west1-z"}} {
+		if _, err := lookupZones(context.Background(), svc, "p", "", names); err == nil {
+			t.Fatalf("%v: expected error", names)
+		}
+	}
+}
+
+func TestLookupMIGInstances(t *testing.T) {
+	svc, client, done := testService(t)
+	defer done()
+
+	instances, err := lookupMIGInstances(context.Background(), svc, client, "p", "consul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		"https://www.googleapis.com/compute/v1/projects/p/zones/us-west1-a/instances/consul-1": true,
+		"https://www.googleapis.com/compute/v1/projects/p/zones/us-west1-b/instances/consul-3": true,
+		"https://www.googleapis.com/compute/v1/projects/p/zones/us-east1-b/instances/consul-2": true,
+	}
+	if !reflect.DeepEqual(instances, want) {
+		t.Fatalf("got %v want %v", instances, want)
+	}
+
+	zones := instanceZones([]string{"us-west1-a", "us-west1-b", "us-east1-b"}, instances)
+	if want := []string{"us-west1-a", "us-west1-b", "us-east1-b"}; !reflect.DeepEqual(zones, want) {
+		t.Fatalf("got zones %v want %v", zones, want)
+	}
+
+	if _, err := lookupMIGInstances(context.Background(), svc, client, "p", "vault"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestLookupAddrs(t *testing.T) {
+	svc, _, done := testService(t)
+	defer done()
+
+	mig := map[string]bool{"https://www.googleapis.com/compute/v1/projects/p/zones/us-west1-a/instances/consul-1": true}
+	tests := []struct {
+		name      string
+		tag       string
+		instances map[string]bool
+		addrType  string
+		addrs     []string
+	}{
+		{"tag", "consul", nil, "private_v4", []string{"10.0.0.3"}},
+		{"no tag", "", nil, "private_v4", nil},
+		{"mig", "", mig, "private_v4", []string{"10.0.0.1"}},
+		{"mig and tag", "consul", mig, "private_v4", nil},
+		{"alias", "", mig, "alias_v4", []string{"10.1.0.1", "10.3.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addrs, err := lookupAddrs(context.Background(), svc, "p", "us-west1-a", tt.tag, tt.instances, tt.addrType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(addrs, tt.addrs) {
+				t.Fatalf("got %v want %v", addrs, tt.addrs)
+			}
+		})
+	}
+}
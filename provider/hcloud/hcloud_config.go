@@ -0,0 +1,161 @@
+package hcloud
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+// config is the parsed configuration of a lookup.
+type config struct {
+	apiToken string
+	endpoint string
+
+	addressType    string
+	locations      []string
+	labelSelector  string
+	subnet         string
+	subnetFilter   serverFilter
+	serverType     string
+	image          string
+	placementGroup string
+	certificate    string
+	statuses       []hcloud.ServerStatus
+
+	network        string
+	networkID      int
+	requireNetwork bool
+
+	// createdBy is the "<created_by_label>=<created_by>" selector added to
+	// labelSelector or empty if created_by is not set.
+	createdBy string
+
+	timeout                   time.Duration
+	preferOtherPlacementGroup bool
+	hostnameFallback          bool
+}
+
+// parseConfig parses and validates the arguments of a lookup without any
+// API calls. It is used by Nodes and Ping so that both reject the same
+// invalid configs. An invalid address_type is logged and replaced with
+// private_v4 to keep existing configs working, Ping rejects it.
+func parseConfig(args map[string]string, l *log.Logger) (*config, error) {
+	if args["provider"] != "hcloud" {
+		return nil, fmt.Errorf("discover-hcloud: invalid provider %s", args["provider"])
+	}
+
+	c := &config{
+		addressType:    args["address_type"],
+		locations:      splitList(argsOrEnv(args, "location", "HCLOUD_LOCATION")),
+		labelSelector:  args["label_selector"],
+		subnet:         args["subnet"],
+		serverType:     args["server_type"],
+		image:          args["image"],
+		placementGroup: args["placement_group"],
+		certificate:    args["certificate"],
+		network:        argsOrEnv(args, "network", "HCLOUD_NETWORK"),
+		timeout:        defaultTimeout,
+	}
+
+	var err error
+	if c.apiToken, err = readAPIToken(args); err != nil {
+		return nil, err
+	}
+	if c.endpoint, err = readEndpoint(args); err != nil {
+		return nil, err
+	}
+
+	if c.addressType == "" {
+		c.addressType = "private_v4"
+	}
+	if _, ok := addressTypes[c.addressType]; !ok {
+		l.Printf("[INFO] discover-hcloud: address_type %s is invalid, falling back to 'private_v4'. valid values are: %s", c.addressType, validAddressTypes)
+		c.addressType = "private_v4"
+	}
+
+	if args["network_id"] != "" {
+		id, err := strconv.Atoi(args["network_id"])
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("discover-hcloud: invalid network_id %q", args["network_id"])
+		}
+		c.networkID = id
+	}
+	if c.network != "" && c.networkID != 0 && c.network != strconv.Itoa(c.networkID) {
+		return nil, fmt.Errorf("discover-hcloud: network %s and network_id %d do not match", c.network, c.networkID)
+	}
+
+	if c.requireNetwork, err = parseBool(args, "require_network", false); err != nil {
+		return nil, err
+	}
+	if c.preferOtherPlacementGroup, err = parseBool(args, "prefer_other_placement_group", false); err != nil {
+		return nil, err
+	}
+	if c.hostnameFallback, err = parseBool(args, "hostname_fallback", true); err != nil {
+		return nil, err
+	}
+
+	if c.subnet != "" {
+		f, err := subnetFilter(c.subnet)
+		if err != nil {
+			return nil, fmt.Errorf("discover-hcloud: %s", err)
+		}
+		c.subnetFilter = f
+	}
+
+	if args["status"] != "" && args["statuses"] != "" {
+		return nil, fmt.Errorf("discover-hcloud: only one of status and statuses may be set")
+	}
+	if c.statuses, err = parseStatuses(args["statuses"] + args["status"]); err != nil {
+		return nil, err
+	}
+
+	if args["timeout"] != "" {
+		d, err := time.ParseDuration(args["timeout"])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("discover-hcloud: invalid timeout %q", args["timeout"])
+		}
+		c.timeout = d
+	}
+
+	if createdBy := args["created_by"]; createdBy != "" {
+		label := args["created_by_label"]
+		if label == "" {
+			label = "created-by"
+		}
+		if strings.ContainsAny(createdBy+label, ",=! ()") {
+			return nil, fmt.Errorf("discover-hcloud: invalid created_by %s=%s", label, createdBy)
+		}
+		c.createdBy = label + "=" + createdBy
+		c.labelSelector = joinLabelSelectors(c.labelSelector, c.createdBy)
+	}
+
+	return c, nil
+}
+
+// parseBool parses the boolean argument key or returns def if it is not set.
+func parseBool(args map[string]string, key string, def bool) (bool, error) {
+	if args[key] == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(args[key])
+	if err != nil {
+		return false, fmt.Errorf("discover-hcloud: invalid %s %q", key, args[key])
+	}
+	return b, nil
+}
+
+// networkNameOrID returns the name or ID of the network to look up or an
+// empty string if neither network nor network_id is set.
+func (c *config) networkNameOrID() string {
+	if c.network != "" {
+		return c.network
+	}
+	if c.networkID != 0 {
+		return strconv.Itoa(c.networkID)
+	}
+	return ""
+}
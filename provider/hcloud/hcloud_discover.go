@@ -6,31 +6,135 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/hashicorp/go-discover/provider"
 	"github.com/hetznercloud/hcloud-go/hcloud"
+	"github.com/hetznercloud/hcloud-go/hcloud/metadata"
 )
 
-type Provider struct{}
+// defaultTimeout is the default timeout for all API calls of a lookup.
+const defaultTimeout = 30 * time.Second
+
+// metadataTimeout is the maximum time to wait for the metadata service, which
+// is only reachable from hcloud servers.
+const metadataTimeout = 2 * time.Second
+
+// metadataEndpoint is the endpoint of the hcloud metadata service.
+var metadataEndpoint = metadata.Endpoint
+
+// ServerAPI is the part of the hcloud server API used to look up servers.
+// The Server field of hcloud.Client implements it.
+type ServerAPI interface {
+	GetByID(ctx context.Context, id int) (*hcloud.Server, *hcloud.Response, error)
+	GetByName(ctx context.Context, name string) (*hcloud.Server, *hcloud.Response, error)
+	AllWithOpts(ctx context.Context, opts hcloud.ServerListOpts) ([]*hcloud.Server, error)
+}
+
+// NetworkAPI is the part of the hcloud network API used to look up the
+// network of the network and network_id arguments. The Network field of
+// hcloud.Client implements it.
+type NetworkAPI interface {
+	Get(ctx context.Context, idOrName string) (*hcloud.Network, *hcloud.Response, error)
+}
+
+// CertificateAPI is the part of the hcloud certificate API used by the
+// certificate filter. The Certificate field of hcloud.Client implements it.
+type CertificateAPI interface {
+	Get(ctx context.Context, idOrName string) (*hcloud.Certificate, *hcloud.Response, error)
+}
+
+// LoadBalancerAPI is the part of the hcloud load balancer API used by the
+// certificate filter. The LoadBalancer field of hcloud.Client implements it.
+type LoadBalancerAPI interface {
+	GetByID(ctx context.Context, id int) (*hcloud.LoadBalancer, *hcloud.Response, error)
+}
+
+// FloatingIPAPI is the part of the hcloud floating IP API used to look up the
+// floating IPs of the servers. The FloatingIP field of hcloud.Client
+// implements it.
+type FloatingIPAPI interface {
+	List(ctx context.Context, opts hcloud.FloatingIPListOpts) ([]*hcloud.FloatingIP, *hcloud.Response, error)
+}
+
+type Provider struct {
+	// NewServerAPI returns the server API to use for the given API token.
+	// If nil, the server API of the hcloud-go client is used. This allows
+	// testing the lookup without real API calls.
+	NewServerAPI func(apiToken string) ServerAPI
+
+	// NewNetworkAPI returns the network API to use for the given API token
+	// like NewServerAPI.
+	NewNetworkAPI func(apiToken string) NetworkAPI
+
+	// NewCertificateAPI and NewLoadBalancerAPI return the certificate and
+	// load balancer APIs to use for the given API token like NewServerAPI.
+	NewCertificateAPI  func(apiToken string) CertificateAPI
+	NewLoadBalancerAPI func(apiToken string) LoadBalancerAPI
+
+	// NewFloatingIPAPI returns the floating IP API to use for the given API
+	// token like NewServerAPI.
+	NewFloatingIPAPI func(apiToken string) FloatingIPAPI
+}
 
 func (p *Provider) Help() string {
 	return `Hetzner Cloud:
 		provider:       "hcloud"
 		api_token:      The Hetzner Cloud API token to use
+		token_file:     A file to read the API token from. Used if api_token is not set.
+		endpoint:       The base URL of the hcloud API (eg. "https://proxy.example.com/v1"). Optional. (default: the public
+		                Hetzner Cloud API)
 		location:       The Hetzner Cloud datacenter location to filter by (eg. "fsn1"). Optional. If empty, will detect the location of the current server.
+		                A comma separated list matches servers in any of the locations (eg. "fsn1,nbg1,hel1").
+		                The current server is identified by the metadata service, or by /etc/hostname if it is unreachable.
 										If not on an hcloud server, will connect to all servers matching label_selector.
 		label_selector: The label selector to filter by
-		address_type:   "private_v4", "public_v4" or "public_v6". (default: "private_v4") In the case of private networks, the first one will be used.
+		subnet:         A private subnet in CIDR notation to filter by (eg. "10.0.1.0/24"). Optional. Servers need a private
+		                IP address in this subnet. Combined with label_selector servers have to match both.
+		server_type:    The server type to filter by (eg. "cx21"). Optional. Matched exactly, ignoring case.
+		image:          The image name to filter by (eg. "ubuntu-22.04"). Optional. Matched exactly, ignoring case.
+		created_by:     The tool or pipeline which created the servers to filter by (eg. "terraform"). Optional. Shorthand for
+		                adding "<created_by_label>=<created_by>" to label_selector.
+		created_by_label: The label key used by created_by. (default: "created-by")
+		address_type:   "private_v4", "public_v4", "public_v6", "public_dual", "all" or "auto". (default: "private_v4") In the case of
+		                private networks, the first one will be used unless network is set. "public_dual" returns the public IPv4 and
+		                IPv6 address of every server, "all" additionally the private one. "auto" returns the first address a server has
+		                of "private_v4", "public_v4" and "public_v6". "floating_v4" and "floating_v6" return only the first
+		                non-blocked floating IP of that type and skip servers without one.
+		network:        The name or ID of the private network to use. Optional. Servers which are not attached to it are skipped
+		                and "private_v4" returns the IP address on this network.
+		network_id:     The ID of the private network to use. Same as network with an ID.
+		require_network: "true" to fail if a server matching the filters is not attached to network. (default: "false")
+		prefer_other_placement_group: "true" to return the servers in the placement group of the current server last to
+		                favor peers in other failure domains. Requires detecting the current server. (default: "false")
+		hostname_fallback: "false" to not look up the current server by the name in /etc/hostname if the metadata service
+		                is unreachable, e.g. in containers whose hostname is not the server name. (default: "true")
+		statuses:       A comma separated list of server statuses to filter by (eg. "running,starting"). (default: "running")
+		status:         Alias for statuses.
+		placement_group: The name or ID of a placement group to filter by. Optional. Only its members are returned.
+		timeout:        The timeout for all API calls of a lookup (eg. "10s"). Rate limited requests and server errors are
+		                retried with exponential backoff until the timeout expires. (default: "30s")
+		certificate:    The name or ID of a certificate to filter by. Optional. Only servers which are targets of a load
+		                balancer serving this certificate are returned. Servers are never linked to certificates directly,
+		                so this costs one API call for the certificate and one per load balancer using it.
 
 		Variables can also be provided by environment variables:
 		export HCLOUD_LOCATION for location
-		export HCLOUD_TOKEN for api_token
+		export HCLOUD_NETWORK for network
+		export HCLOUD_TOKEN_FILE for token_file
+		export HCLOUD_ENDPOINT for endpoint
+		export HCLOUD_TOKEN for api_token (used if neither api_token nor token_file is set)
 `
 }
 
 // serverIP returns the IP address of the specified type for the hcloud server.
-func serverIP(s *hcloud.Server, addrType string, l *log.Logger) string {
+// If networkID is not zero the private IP address on that network is used.
+func serverIP(s *hcloud.Server, addrType string, networkID int, l *log.Logger) string {
 	switch addrType {
 	case "public_v4":
 		if !s.PublicNet.IPv4.Blocked {
@@ -58,12 +162,25 @@ func serverIP(s *hcloud.Server, addrType string, l *log.Logger) string {
 				}
 			}
 		}
+	case "floating_v4":
+		if ip := floatingIP(s, hcloud.FloatingIPTypeIPv4); ip != "" {
+			l.Printf("[INFO] discover-hcloud: instance %s (%d) has floating IP %s", s.Name, s.ID, ip)
+			return ip
+		}
+	case "floating_v6":
+		if ip := floatingIP(s, hcloud.FloatingIPTypeIPv6); ip != "" {
+			l.Printf("[INFO] discover-hcloud: instance %s (%d) has floating IP %s", s.Name, s.ID, ip)
+			return ip
+		}
 	case "private_v4":
 		if len(s.PrivateNet) == 0 {
 			l.Printf("[INFO] discover-hcloud: instance %s (%d) has no private IP", s.Name, s.ID)
-		} else {
+		} else if networkID == 0 {
 			l.Printf("[INFO] discover-hcloud: instance %s (%d) has private IP %s", s.Name, s.ID, s.PrivateNet[0].IP.String())
 			return s.PrivateNet[0].IP.String()
+		} else if privateNet := serverPrivateNet(s, networkID); privateNet != nil {
+			l.Printf("[INFO] discover-hcloud: instance %s (%d) has private IP %s on network %d", s.Name, s.ID, privateNet.IP.String(), networkID)
+			return privateNet.IP.String()
 		}
 	default:
 	}
@@ -72,97 +189,649 @@ func serverIP(s *hcloud.Server, addrType string, l *log.Logger) string {
 	return ""
 }
 
+// floatingIP returns the first non-blocked floating IP of the given type
+// assigned to the hcloud server or an empty string if there is none.
+func floatingIP(s *hcloud.Server, typ hcloud.FloatingIPType) string {
+	for _, floatingIP := range s.PublicNet.FloatingIPs {
+		if floatingIP.Type == typ && !floatingIP.Blocked {
+			return floatingIP.IP.String()
+		}
+	}
+	return ""
+}
+
+// addressTypes maps the valid address types to the single address types
+// they are composed of in the order in which they are returned. For "auto"
+// only the first address found is returned.
+var addressTypes = map[string][]string{
+	"private_v4":  {"private_v4"},
+	"public_v4":   {"public_v4"},
+	"public_v6":   {"public_v6"},
+	"public_dual": {"public_v4", "public_v6"},
+	"all":         {"private_v4", "public_v4", "public_v6"},
+	"auto":        {"private_v4", "public_v4", "public_v6"},
+	"floating_v4": {"floating_v4"},
+	"floating_v6": {"floating_v6"},
+}
+
+// validAddressTypes lists the keys of addressTypes for error messages.
+const validAddressTypes = "private_v4, public_v4, public_v6, public_dual, all, auto, floating_v4, floating_v6"
+
+// serverIPs returns the IP addresses of the specified type for the hcloud
+// server. Composite types like "public_dual" return an address for every
+// single type the server has one for, IPv4 before IPv6. "auto" returns the
+// address of the first single type the server has one for.
+func serverIPs(s *hcloud.Server, addrType string, networkID int, l *log.Logger) []string {
+	if addrType == "auto" {
+		for _, typ := range addressTypes[addrType] {
+			if ip := serverIP(s, typ, networkID, l); ip != "" {
+				l.Printf("[INFO] discover-hcloud: instance %s (%d) selected address type %s", s.Name, s.ID, typ)
+				return []string{ip}
+			}
+		}
+		return nil
+	}
+
+	var ips []string
+	for _, typ := range addressTypes[addrType] {
+		if ip := serverIP(s, typ, networkID, l); ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// serverPrivateNet returns the attachment of the hcloud server to the private
+// network with the given ID or nil if the server is not attached to it.
+func serverPrivateNet(s *hcloud.Server, networkID int) *hcloud.ServerPrivateNet {
+	for i, privateNet := range s.PrivateNet {
+		if privateNet.Network != nil && privateNet.Network.ID == networkID {
+			return &s.PrivateNet[i]
+		}
+	}
+	return nil
+}
+
+// apiError returns the error of an API call or a timeout error if ctx has
+// expired.
+func apiError(ctx context.Context, timeout time.Duration, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &lookupError{msg: fmt.Sprintf("discover-hcloud: timed out after %s", timeout), timeout: true}
+	}
+	return &lookupError{msg: fmt.Sprintf("discover-hcloud: %s", err), cause: err}
+}
+
+// networkByNameOrID returns the ID of the network with the given name or ID.
+// Networks given by ID are looked up as well to fail for unknown IDs.
+func networkByNameOrID(ctx context.Context, networkAPI NetworkAPI, network string, l *log.Logger) (int, error) {
+	var n *hcloud.Network
+	err := retry(ctx, "looking up network", l, func() error {
+		var err error
+		n, _, err = networkAPI.Get(ctx, network)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if n == nil {
+		return 0, fmt.Errorf("network %s not found", network)
+	}
+	return n.ID, nil
+}
+
+// currentServer returns the hcloud server this process is running on. The
+// server ID is read from the metadata service and only if it is unreachable
+// and hostnameFallback is set the server with the name from /etc/hostname is
+// looked up instead. It returns nil if there is no such server.
+func currentServer(ctx context.Context, serverAPI ServerAPI, hostnameFallback bool, l *log.Logger) (*hcloud.Server, error) {
+	id, err := metadataInstanceID(ctx)
+	if err != nil {
+		if !hostnameFallback {
+			l.Printf("[INFO] discover-hcloud: Metadata service unreachable, not an hcloud server: %s", err)
+			return nil, nil
+		}
+		l.Printf("[INFO] discover-hcloud: Metadata service unreachable, falling back to /etc/hostname: %s", err)
+		return currentServerByHostname(ctx, serverAPI, l)
+	}
+
+	l.Printf("[INFO] discover-hcloud: Searching for current server with id %d.", id)
+
+	var server *hcloud.Server
+	err = retry(ctx, "looking up current server", l, func() error {
+		var err error
+		server, _, err = serverAPI.GetByID(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if server != nil {
+		l.Printf("[INFO] discover-hcloud: Detected current server %s with id %d", server.Name, server.ID)
+	}
+	return server, nil
+}
+
+// metadataInstanceID returns the ID of the current server from the metadata
+// service. The request is bounded by metadataTimeout and the deadline of ctx
+// so that it fails fast when not running on an hcloud server.
+func metadataInstanceID(ctx context.Context) (int, error) {
+	timeout := metadataTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return 0, ctx.Err()
+		}
+		if remaining < timeout {
+			timeout = remaining
+		}
+	}
+
+	client := metadata.NewClient(
+		metadata.WithEndpoint(metadataEndpoint),
+		metadata.WithHTTPClient(&http.Client{Timeout: timeout}),
+	)
+	return client.InstanceID()
+}
+
+// currentServerByHostname returns the hcloud server this process is running
+// on by looking up the server with the name from /etc/hostname. It returns nil
+// if there is no such server.
+func currentServerByHostname(ctx context.Context, serverAPI ServerAPI, l *log.Logger) (*hcloud.Server, error) {
+	content, err := ioutil.ReadFile("/etc/hostname")
+	if err != nil {
+		return nil, err
+	}
+
+	hostname := strings.TrimSpace(string(content))
+
+	l.Printf("[INFO] discover-hcloud: Searching for current server named %s.", hostname)
+
+	var server *hcloud.Server
+	err = retry(ctx, "looking up current server", l, func() error {
+		var err error
+		server, _, err = serverAPI.GetByName(ctx, hostname)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if server != nil {
+		l.Printf("[INFO] discover-hcloud: Detected current server %s with id %d", server.Name, server.ID)
+	}
+	return server, nil
+}
+
+// certificateServerIDs returns the IDs of the servers which are targets of the
+// load balancers using the certificate with the given name or ID. The load
+// balancers are fetched with at most concurrency requests at the same time.
+func certificateServerIDs(ctx context.Context, certAPI CertificateAPI, lbAPI LoadBalancerAPI, certificate string, concurrency int, l *log.Logger) (map[int]bool, error) {
+	var cert *hcloud.Certificate
+	err := retry(ctx, "looking up certificate", l, func() error {
+		var err error
+		cert, _, err = certAPI.Get(ctx, certificate)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cert == nil {
+		return nil, fmt.Errorf("certificate %s not found", certificate)
+	}
+
+	var lbIDs []int
+	for _, ref := range cert.UsedBy {
+		if ref.Type == hcloud.CertificateUsedByRefTypeLoadBalancer {
+			lbIDs = append(lbIDs, ref.ID)
+		}
+	}
+
+	lbs := make([]*hcloud.LoadBalancer, len(lbIDs))
+	errs := make([]error, len(lbIDs))
+	provider.ForEach(len(lbIDs), concurrency, func(i int) {
+		errs[i] = retry(ctx, "looking up load balancer", l, func() error {
+			var err error
+			lbs[i], _, err = lbAPI.GetByID(ctx, lbIDs[i])
+			return err
+		})
+	})
+
+	ids := map[int]bool{}
+	for i, lb := range lbs {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if lb == nil {
+			continue
+		}
+		l.Printf("[DEBUG] discover-hcloud: certificate %s is used by load balancer %s (%d)", cert.Name, lb.Name, lb.ID)
+		addTargetServerIDs(ids, lb.Targets)
+	}
+
+	if len(ids) == 0 {
+		l.Printf("[INFO] discover-hcloud: certificate %s is not used by any load balancer with server targets", cert.Name)
+	}
+	return ids, nil
+}
+
+// addTargetServerIDs adds the IDs of all servers in the load balancer targets
+// to ids. Label selector targets are resolved through their sub targets.
+func addTargetServerIDs(ids map[int]bool, targets []hcloud.LoadBalancerTarget) {
+	for _, t := range targets {
+		if t.Server != nil && t.Server.Server != nil {
+			ids[t.Server.Server.ID] = true
+		}
+		addTargetServerIDs(ids, t.Targets)
+	}
+}
+
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
-	if args["provider"] != "hcloud" {
-		return nil, fmt.Errorf("discover-hcloud: invalid provider %s", args["provider"])
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs. All API calls are aborted
+// when ctx is done or the timeout expires, whichever happens first.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
+	nodes, err := p.Nodes(ctx, args, l)
+	if err != nil {
+		return nil, err
 	}
+	return provider.Addrs(nodes), nil
+}
 
+// Nodes looks up the servers like AddrsContext and returns a node with the
+// server name, ID, location and labels for every address. The labels are
+// added to Meta with a "label:" prefix next to the name of the datacenter.
+func (p *Provider) Nodes(ctx context.Context, args map[string]string, l *log.Logger) ([]provider.Node, error) {
 	if l == nil {
 		l = log.New(ioutil.Discard, "", 0)
 	}
 
-	addressType := args["address_type"]
-	location := argsOrEnv(args, "location", "HCLOUD_LOCATION")
-	labelSelector := args["label_selector"]
-	apiToken := argsOrEnv(args, "api_token", "HCLOUD_TOKEN")
+	c, err := parseConfig(args, l)
+	if err != nil {
+		return nil, err
+	}
 
-	if apiToken == "" {
-		return nil, fmt.Errorf("discover-hcloud: no API token specified")
+	concurrency, err := provider.Concurrency(args, provider.ContextConcurrency(ctx, 1))
+	if err != nil {
+		return nil, fmt.Errorf("discover-hcloud: %s", err)
 	}
 
-	client := getHcloudClient(apiToken)
+	addressType := c.addressType
+	locations := c.locations
+	labelSelector := c.labelSelector
+	networkID := c.networkID
+	timeout := c.timeout
 
-	if location == "" {
-		content, err := ioutil.ReadFile("/etc/hostname")
-		if err != nil {
-			return nil, fmt.Errorf("discover-hcloud: %s", err)
-		}
+	if args["address_type"] == "" {
+		l.Printf("[INFO] discover-hcloud: address type not provided, using 'private_v4'")
+	}
+
+	if c.requireNetwork && c.network == "" && networkID == 0 {
+		l.Printf("[INFO] discover-hcloud: require_network has no effect without network")
+	}
 
-		hostname := strings.TrimSpace(string(content))
+	if c.createdBy != "" {
+		l.Printf("[INFO] discover-hcloud: filtering by %s", c.createdBy)
+	}
 
-		l.Printf("[INFO] discover-hcloud: Location not specified. Searching for current server named %s.", hostname)
+	client := getHcloudClient(c.apiToken, c.endpoint)
+	serverAPI := p.serverAPI(client, c.apiToken)
 
-		server, _, err := client.Server.GetByName(context.Background(), hostname)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
+	var self *hcloud.Server
+	if len(locations) == 0 {
+		l.Printf("[INFO] discover-hcloud: Location not specified, detecting the location of the current server.")
+		server, err := currentServer(ctx, serverAPI, c.hostnameFallback, l)
 		if err != nil {
-			return nil, fmt.Errorf("discover-hcloud: %s", err)
+			return nil, apiError(ctx, timeout, err)
 		}
+		self = server
 
 		if server != nil {
-			l.Printf("[INFO] discover-hcloud: Detected current server %s with id %d", server.Name, server.ID)
-
-			location = server.Datacenter.Location.Name
+			locations = []string{server.Datacenter.Location.Name}
 		} else {
 			l.Printf("[INFO] discover-hcloud: No location specified and not an hcloud server. Joining all matching label selector.")
 		}
+	} else if c.preferOtherPlacementGroup {
+		server, err := currentServer(ctx, serverAPI, c.hostnameFallback, l)
+		if err != nil {
+			l.Printf("[INFO] discover-hcloud: Cannot detect current server, not ordering by placement group: %s", err)
+		}
+		self = server
 	}
 
-	if addressType == "" {
-		l.Printf("[INFO] discover-hcloud: address type not provided, using 'private_v4'")
-		addressType = "private_v4"
+	if len(locations) != 0 {
+		l.Printf("[INFO] discover-hcloud: filtering by location %s", strings.Join(locations, ", "))
+	}
+
+	if network := c.networkNameOrID(); network != "" {
+		id, err := networkByNameOrID(ctx, p.networkAPI(client, c.apiToken), network, l)
+		if err != nil {
+			return nil, apiError(ctx, timeout, err)
+		}
+		networkID = id
 	}
 
-	if addressType != "private_v4" && addressType != "public_v4" && addressType != "public_v6" {
-		l.Printf("[INFO] discover-hcloud: address_type %s is invalid, falling back to 'private_v4'. valid values are: private_v4, public_v4, public_v6", addressType)
-		addressType = "private_v4"
+	if networkID != 0 {
+		l.Printf("[INFO] discover-hcloud: filtering by network %d", networkID)
 	}
 
-	if location != "" {
-		l.Printf("[INFO] discover-hcloud: filtering by location %s", location)
+	var certServerIDs map[int]bool
+	if c.certificate != "" {
+		l.Printf("[INFO] discover-hcloud: filtering by certificate %s", c.certificate)
+		ids, err := certificateServerIDs(ctx, p.certificateAPI(client, c.apiToken), p.loadBalancerAPI(client, c.apiToken), c.certificate, concurrency, l)
+		if err != nil {
+			return nil, apiError(ctx, timeout, err)
+		}
+		certServerIDs = ids
+	}
+
+	var filters []serverFilter
+	if len(locations) != 0 {
+		filters = append(filters, locationFilter(locations))
+	}
+	if certServerIDs != nil {
+		filters = append(filters, serverIDFilter("certificate "+c.certificate, certServerIDs))
+	}
+	if labelSelector != "" {
+		f, err := labelFilter(labelSelector)
+		if err != nil {
+			l.Printf("[DEBUG] discover-hcloud: cannot evaluate label_selector %s locally, relying on the API: %s", labelSelector, err)
+		} else {
+			filters = append(filters, f)
+		}
+	}
+	if c.subnet != "" {
+		l.Printf("[INFO] discover-hcloud: filtering by subnet %s", c.subnet)
+		filters = append(filters, c.subnetFilter)
+	}
+	if c.serverType != "" {
+		filters = append(filters, serverTypeFilter(c.serverType))
+	}
+	if c.image != "" {
+		filters = append(filters, imageFilter(c.image))
+	}
+	if c.placementGroup != "" {
+		filters = append(filters, placementGroupFilter(c.placementGroup))
 	}
 
-	l.Printf("[DEBUG] discover-hcloud: using address_type=%s label_selector=%s location=%s", addressType, labelSelector, location)
+	l.Printf("[DEBUG] discover-hcloud: using address_type=%s label_selector=%s location=%s network_id=%d certificate=%s subnet=%s server_type=%s image=%s placement_group=%s statuses=%v", addressType, labelSelector, strings.Join(locations, ","), networkID, c.certificate, c.subnet, c.serverType, c.image, c.placementGroup, c.statuses)
 
 	options := hcloud.ServerListOpts{
 		ListOpts: hcloud.ListOpts{
 			LabelSelector: labelSelector,
 		},
-		Status: []hcloud.ServerStatus{hcloud.ServerStatusRunning},
+		Status: c.statuses,
 	}
 
-	servers, err := client.Server.AllWithOpts(context.Background(), options)
+	servers, err := listServers(ctx, serverAPI, options, concurrency, l)
 	if err != nil {
-		return nil, fmt.Errorf("discover-hcloud: %s", err)
+		return nil, apiError(ctx, timeout, err)
 	}
 
+	servers = filterServers(servers, filters, l)
+
+	if addressType != "private_v4" {
+		if err := fillFloatingIPs(ctx, p.floatingIPAPI(client, c.apiToken), servers, concurrency, l); err != nil {
+			return nil, apiError(ctx, timeout, err)
+		}
+	}
+
+	if c.preferOtherPlacementGroup {
+		if self != nil && self.PlacementGroup != nil {
+			l.Printf("[INFO] discover-hcloud: ordering servers in placement group %s last", self.PlacementGroup.Name)
+			servers = sortOtherPlacementGroupsFirst(servers, self.PlacementGroup.ID)
+		} else {
+			l.Printf("[INFO] discover-hcloud: placement group of current server unknown, not ordering by placement group")
+		}
+	}
+
+	var nodes []provider.Node
 	var addrs []string
+	var detached []string
 	for _, s := range servers {
-		if location == "" || location == s.Datacenter.Location.Name {
-			if serverIP := serverIP(s, addressType, l); serverIP != "" {
-				addrs = append(addrs, serverIP)
+		if networkID != 0 && serverPrivateNet(s, networkID) == nil {
+			l.Printf("[DEBUG] discover-hcloud: instance %s (%d) is not attached to network %d", s.Name, s.ID, networkID)
+			detached = append(detached, fmt.Sprintf("%s (%d)", s.Name, s.ID))
+			continue
+		}
+		for _, addr := range serverIPs(s, addressType, networkID, l) {
+			nodes = append(nodes, serverNode(s, addr))
+			addrs = append(addrs, addr)
+		}
+	}
+
+	if c.requireNetwork && len(detached) > 0 {
+		return nil, fmt.Errorf("discover-hcloud: servers not attached to network %d: %s", networkID, strings.Join(detached, ", "))
+	}
+
+	l.Printf("[DEBUG] discover-hcloud: found IP addresses: %v", addrs)
+	return nodes, nil
+}
+
+// serverNode returns the node for the address of the hcloud server.
+func serverNode(s *hcloud.Server, addr string) provider.Node {
+	n := provider.Node{
+		Addr: addr,
+		Name: s.Name,
+		ID:   strconv.Itoa(s.ID),
+		Meta: map[string]string{},
+	}
+	if s.Datacenter != nil {
+		n.Meta["datacenter"] = s.Datacenter.Name
+		if s.Datacenter.Location != nil {
+			n.Zone = s.Datacenter.Location.Name
+		}
+	}
+	for k, v := range s.Labels {
+		n.Meta["label:"+k] = v
+	}
+	return n
+}
+
+// Ping validates the arguments like Nodes and checks that the hcloud API can
+// be reached with the configured API token by listing at most one server.
+// The network is looked up to check that it exists. Unlike Nodes it rejects
+// an invalid address_type.
+func (p *Provider) Ping(ctx context.Context, args map[string]string, l *log.Logger) error {
+	if l == nil {
+		l = log.New(ioutil.Discard, "", 0)
+	}
+
+	if t := args["address_type"]; t != "" {
+		if _, ok := addressTypes[t]; !ok {
+			return fmt.Errorf("discover-hcloud: invalid address_type %q. valid values are: %s", t, validAddressTypes)
+		}
+	}
+	c, err := parseConfig(args, l)
+	if err != nil {
+		return err
+	}
+
+	client := getHcloudClient(c.apiToken, c.endpoint)
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	if err := pingServers(ctx, p.serverAPI(client, c.apiToken)); err != nil {
+		return apiError(ctx, c.timeout, err)
+	}
+
+	if network := c.networkNameOrID(); network != "" {
+		if _, err := networkByNameOrID(ctx, p.networkAPI(client, c.apiToken), network, l); err != nil {
+			return apiError(ctx, c.timeout, err)
+		}
+	}
+	return nil
+}
+
+// joinLabelSelectors combines the non-empty label selectors so that a server
+// has to match all of them.
+func joinLabelSelectors(selectors ...string) string {
+	var parts []string
+	for _, s := range selectors {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// serverStatuses are the valid values of the statuses argument.
+var serverStatuses = []hcloud.ServerStatus{
+	hcloud.ServerStatusInitializing,
+	hcloud.ServerStatusStarting,
+	hcloud.ServerStatusRunning,
+	hcloud.ServerStatusStopping,
+	hcloud.ServerStatusOff,
+	hcloud.ServerStatusDeleting,
+	hcloud.ServerStatusMigrating,
+	hcloud.ServerStatusRebuilding,
+	hcloud.ServerStatusUnknown,
+}
+
+// parseStatuses parses a comma separated list of server statuses. An empty
+// list selects running servers only.
+func parseStatuses(s string) ([]hcloud.ServerStatus, error) {
+	list := splitList(s)
+	if len(list) == 0 {
+		return []hcloud.ServerStatus{hcloud.ServerStatusRunning}, nil
+	}
+
+	var statuses []hcloud.ServerStatus
+	for _, v := range list {
+		status, ok := serverStatus(v)
+		if !ok {
+			var valid []string
+			for _, status := range serverStatuses {
+				valid = append(valid, string(status))
 			}
+			return nil, fmt.Errorf("discover-hcloud: invalid status %q. valid values are: %s", v, strings.Join(valid, ", "))
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// serverStatus returns the server status with the given name.
+func serverStatus(name string) (hcloud.ServerStatus, bool) {
+	for _, status := range serverStatuses {
+		if string(status) == name {
+			return status, true
+		}
+	}
+	return "", false
+}
+
+// readAPIToken returns the API token from api_token, the file named by
+// token_file or HCLOUD_TOKEN_FILE, or HCLOUD_TOKEN in this order.
+func readAPIToken(args map[string]string) (string, error) {
+	if token := args["api_token"]; token != "" {
+		return token, nil
+	}
+
+	if file := argsOrEnv(args, "token_file", "HCLOUD_TOKEN_FILE"); file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("discover-hcloud: reading token_file: %s", err)
+		}
+		token := strings.TrimSpace(string(content))
+		if token == "" {
+			return "", fmt.Errorf("discover-hcloud: reading token_file: %s is empty", file)
 		}
+		return token, nil
 	}
 
-	log.Printf("[DEBUG] discover-hcloud: found IP addresses: %v", addrs)
-	return addrs, nil
+	if token := os.Getenv("HCLOUD_TOKEN"); token != "" {
+		return token, nil
+	}
+	return "", fmt.Errorf("discover-hcloud: no API token specified")
+}
+
+// readEndpoint returns the API endpoint from endpoint or HCLOUD_ENDPOINT. An
+// empty endpoint selects the default public API.
+func readEndpoint(args map[string]string) (string, error) {
+	endpoint := argsOrEnv(args, "endpoint", "HCLOUD_ENDPOINT")
+	if endpoint == "" {
+		return "", nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("discover-hcloud: invalid endpoint %q: %s", endpoint, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("discover-hcloud: invalid endpoint %q: must be an http or https URL", endpoint)
+	}
+	return endpoint, nil
 }
 
-func getHcloudClient(apiToken string) *hcloud.Client {
-	client := hcloud.NewClient(hcloud.WithToken(apiToken))
+// serverAPI returns the server API to use for looking up servers.
+func (p *Provider) serverAPI(client *hcloud.Client, apiToken string) ServerAPI {
+	if p.NewServerAPI != nil {
+		return p.NewServerAPI(apiToken)
+	}
+	return &client.Server
+}
+
+// networkAPI returns the network API to use for looking up networks.
+func (p *Provider) networkAPI(client *hcloud.Client, apiToken string) NetworkAPI {
+	if p.NewNetworkAPI != nil {
+		return p.NewNetworkAPI(apiToken)
+	}
+	return &client.Network
+}
+
+// certificateAPI returns the certificate API to use for the certificate
+// filter.
+func (p *Provider) certificateAPI(client *hcloud.Client, apiToken string) CertificateAPI {
+	if p.NewCertificateAPI != nil {
+		return p.NewCertificateAPI(apiToken)
+	}
+	return &client.Certificate
+}
+
+// loadBalancerAPI returns the load balancer API to use for the certificate
+// filter.
+func (p *Provider) loadBalancerAPI(client *hcloud.Client, apiToken string) LoadBalancerAPI {
+	if p.NewLoadBalancerAPI != nil {
+		return p.NewLoadBalancerAPI(apiToken)
+	}
+	return &client.LoadBalancer
+}
+
+// floatingIPAPI returns the floating IP API to use for looking up the floating
+// IPs of the servers.
+func (p *Provider) floatingIPAPI(client *hcloud.Client, apiToken string) FloatingIPAPI {
+	if p.NewFloatingIPAPI != nil {
+		return p.NewFloatingIPAPI(apiToken)
+	}
+	return &client.FloatingIP
+}
+
+func getHcloudClient(apiToken, endpoint string) *hcloud.Client {
+	opts := []hcloud.ClientOption{hcloud.WithToken(apiToken)}
+	if endpoint != "" {
+		opts = append(opts, hcloud.WithEndpoint(endpoint))
+	}
+	client := hcloud.NewClient(opts...)
 	return client
 }
 
+// splitList splits a comma separated list and drops empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func argsOrEnv(args map[string]string, key, env string) string {
 	if value := args[key]; value != "" {
 		return value
@@ -1,15 +1,29 @@
 package hcloud_test
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
+	"strconv"
+	"sync"
 	"testing"
 
 	discover "github.com/hashicorp/go-discover"
 	"github.com/hashicorp/go-discover/provider/hcloud"
+	hc "github.com/hetznercloud/hcloud-go/hcloud"
 )
 
 var _ discover.Provider = (*hcloud.Provider)(nil)
+var _ discover.ProviderWithContext = (*hcloud.Provider)(nil)
+var _ discover.NodeProvider = (*hcloud.Provider)(nil)
+var _ discover.ProviderWithRetryable = (*hcloud.Provider)(nil)
+var _ discover.ProviderWithPing = (*hcloud.Provider)(nil)
 var addrTests = map[string]struct {
 	addrType string
 	location string
@@ -45,3 +59,715 @@ func TestAddrs(t *testing.T) {
 		})
 	}
 }
+
+// fakeServerAPI returns canned servers instead of calling the hcloud API.
+type fakeServerAPI struct {
+	servers []*hc.Server
+}
+
+func (f *fakeServerAPI) GetByID(ctx context.Context, id int) (*hc.Server, *hc.Response, error) {
+	for _, s := range f.servers {
+		if s.ID == id {
+			return s, nil, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+func (f *fakeServerAPI) GetByName(ctx context.Context, name string) (*hc.Server, *hc.Response, error) {
+	for _, s := range f.servers {
+		if s.Name == name {
+			return s, nil, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+func (f *fakeServerAPI) AllWithOpts(ctx context.Context, opts hc.ServerListOpts) ([]*hc.Server, error) {
+	var servers []*hc.Server
+	for _, s := range f.servers {
+		for _, status := range opts.Status {
+			if s.Status == status {
+				servers = append(servers, s)
+			}
+		}
+	}
+	return servers, nil
+}
+
+func fakeServer(id int, location, publicIP string, blocked bool, privateIPs ...string) *hc.Server {
+	s := &hc.Server{
+		ID:         id,
+		Name:       fmt.Sprintf("node-%d", id),
+		Status:     hc.ServerStatusRunning,
+		Datacenter: &hc.Datacenter{Location: &hc.Location{Name: location}},
+		PublicNet: hc.ServerPublicNet{
+			IPv4: hc.ServerPublicNetIPv4{IP: net.ParseIP(publicIP), Blocked: blocked},
+			IPv6: hc.ServerPublicNetIPv6{IP: net.ParseIP(fmt.Sprintf("2001:db8:1::%d", id))},
+		},
+	}
+	if blocked {
+		s.PublicNet.FloatingIPs = []*hc.FloatingIP{
+			{Type: hc.FloatingIPTypeIPv6, IP: net.ParseIP("2001:db8::1")},
+			{Type: hc.FloatingIPTypeIPv4, IP: net.ParseIP("198.51.100.1"), Blocked: true},
+			{Type: hc.FloatingIPTypeIPv4, IP: net.ParseIP("198.51.100.2")},
+		}
+	}
+	for _, ip := range privateIPs {
+		s.PrivateNet = append(s.PrivateNet, hc.ServerPrivateNet{IP: net.ParseIP(ip)})
+	}
+	return s
+}
+
+func TestAddrsFake(t *testing.T) {
+	starting := fakeServer(4, "fsn1", "203.0.113.4", false, "10.0.0.4")
+	starting.Status = hc.ServerStatusStarting
+	api := &fakeServerAPI{
+		servers: []*hc.Server{
+			fakeServer(1, "fsn1", "203.0.113.1", false, "10.0.0.1"),
+			fakeServer(2, "nbg1", "203.0.113.2", true, "10.0.0.2"),
+			fakeServer(3, "hel1", "203.0.113.3", false),
+			starting,
+		},
+	}
+	p := &hcloud.Provider{
+		NewServerAPI: func(apiToken string) hcloud.ServerAPI { return api },
+	}
+
+	tests := []struct {
+		name  string
+		args  discover.Config
+		addrs []string
+	}{
+		{
+			"private ipv4 fsn1",
+			discover.Config{"address_type": "private_v4", "location": "fsn1"},
+			[]string{"10.0.0.1"},
+		},
+		{
+			"private ipv4 without private net",
+			discover.Config{"address_type": "private_v4", "location": "fsn1,nbg1,hel1"},
+			[]string{"10.0.0.1", "10.0.0.2"},
+		},
+		{
+			"public ipv4 blocked falls back to floating ip",
+			discover.Config{"address_type": "public_v4", "location": "fsn1,nbg1,hel1"},
+			[]string{"203.0.113.1", "198.51.100.2", "203.0.113.3"},
+		},
+		{
+			"public ipv4 nbg1",
+			discover.Config{"address_type": "public_v4", "location": "nbg1"},
+			[]string{"198.51.100.2"},
+		},
+		{
+			"public dual",
+			discover.Config{"address_type": "public_dual", "location": "nbg1"},
+			[]string{"198.51.100.2", "2001:db8:1::2"},
+		},
+		{
+			"running and starting",
+			discover.Config{"location": "fsn1", "statuses": "running, starting"},
+			[]string{"10.0.0.1", "10.0.0.4"},
+		},
+		{
+			"status alias",
+			discover.Config{"location": "fsn1", "status": "starting"},
+			[]string{"10.0.0.4"},
+		},
+		{
+			"unknown location",
+			discover.Config{"address_type": "public_v4", "location": "ash"},
+			nil,
+		},
+	}
+
+	l := log.New(ioutil.Discard, "", 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.args["provider"] = "hcloud"
+			tt.args["api_token"] = "test"
+			addrs, err := p.Addrs(tt.args, l)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(addrs, tt.addrs) {
+				t.Fatalf("got %v want %v", addrs, tt.addrs)
+			}
+		})
+	}
+}
+
+func TestAddrsInvalidStatus(t *testing.T) {
+	p := &hcloud.Provider{
+		NewServerAPI: func(apiToken string) hcloud.ServerAPI { return &fakeServerAPI{} },
+	}
+	args := discover.Config{"provider": "hcloud", "api_token": "test", "location": "fsn1", "statuses": "running,stopped"}
+	_, err := p.Addrs(args, log.New(ioutil.Discard, "", 0))
+	want := `discover-hcloud: invalid status "stopped". valid values are: initializing, starting, running, stopping, off, deleting, migrating, rebuilding, unknown`
+	if err == nil || err.Error() != want {
+		t.Fatalf("got error %v want %s", err, want)
+	}
+}
+
+func TestAddrsInvalidAddressType(t *testing.T) {
+	p := &hcloud.Provider{
+		NewServerAPI: func(apiToken string) hcloud.ServerAPI {
+			return &fakeServerAPI{servers: []*hc.Server{fakeServer(1, "fsn1", "203.0.113.1", false, "10.0.0.1")}}
+		},
+	}
+
+	// an invalid address_type falls back to private_v4 like it always did
+	args := discover.Config{"provider": "hcloud", "api_token": "test", "location": "fsn1", "address_type": "private"}
+	addrs, err := p.Addrs(args, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1"}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v want %v", addrs, want)
+	}
+}
+
+func TestAddrsPort(t *testing.T) {
+	p := &hcloud.Provider{
+		NewServerAPI: func(apiToken string) hcloud.ServerAPI {
+			return &fakeServerAPI{servers: []*hc.Server{fakeServer(2, "nbg1", "203.0.113.2", true)}}
+		},
+	}
+	d := discover.Discover{Providers: map[string]discover.Provider{"hcloud": p}}
+
+	// the port is appended once by the port option of all providers
+	addrs, err := d.Addrs("provider=hcloud api_token=test location=nbg1 address_type=public_dual port=8301", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"198.51.100.2:8301", "[2001:db8:1::2]:8301"}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v want %v", addrs, want)
+	}
+}
+
+func TestNodesFake(t *testing.T) {
+	s := fakeServer(1, "fsn1", "203.0.113.1", false, "10.0.0.1")
+	s.Datacenter.Name = "fsn1-dc14"
+	s.Labels = map[string]string{"role": "server"}
+	p := &hcloud.Provider{
+		NewServerAPI: func(apiToken string) hcloud.ServerAPI { return &fakeServerAPI{servers: []*hc.Server{s}} },
+	}
+
+	args := discover.Config{"provider": "hcloud", "api_token": "test", "location": "fsn1", "address_type": "public_dual"}
+	nodes, err := p.Nodes(context.Background(), args, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := map[string]string{"datacenter": "fsn1-dc14", "label:role": "server"}
+	want := []discover.Node{
+		{Addr: "203.0.113.1", Name: "node-1", ID: "1", Zone: "fsn1", Meta: meta},
+		{Addr: "2001:db8:1::1", Name: "node-1", ID: "1", Zone: "fsn1", Meta: meta},
+	}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Fatalf("got %+v want %+v", nodes, want)
+	}
+}
+
+func TestAddrsNetworkByName(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/networks" || r.URL.Query().Get("name") != "backend" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"networks": [{"id": 2, "name": "backend", "servers": [1]}]}`))
+	}))
+	defer srv.Close()
+
+	multi := fakeServer(1, "fsn1", "203.0.113.1", false)
+	multi.PrivateNet = []hc.ServerPrivateNet{
+		{Network: &hc.Network{ID: 1}, IP: net.ParseIP("10.0.0.1")},
+		{Network: &hc.Network{ID: 2}, IP: net.ParseIP("10.1.0.1")},
+	}
+	single := fakeServer(2, "fsn1", "203.0.113.2", false)
+	single.PrivateNet = []hc.ServerPrivateNet{
+		{Network: &hc.Network{ID: 1}, IP: net.ParseIP("10.0.0.2")},
+	}
+	p := &hcloud.Provider{
+		NewServerAPI: func(apiToken string) hcloud.ServerAPI {
+			return &fakeServerAPI{servers: []*hc.Server{multi, single}}
+		},
+	}
+
+	args := discover.Config{
+		"provider":  "hcloud",
+		"api_token": "test",
+		"endpoint":  srv.URL,
+		"location":  "fsn1",
+		"network":   "backend",
+	}
+	addrs, err := p.Addrs(args, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.1.0.1"}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v want %v", addrs, want)
+	}
+}
+
+// fakeNetworkAPI returns canned networks instead of calling the hcloud API.
+type fakeNetworkAPI []*hc.Network
+
+func (f fakeNetworkAPI) Get(ctx context.Context, idOrName string) (*hc.Network, *hc.Response, error) {
+	for _, n := range f {
+		if n.Name == idOrName || strconv.Itoa(n.ID) == idOrName {
+			return n, nil, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+func TestAddrsNetwork(t *testing.T) {
+	attached := fakeServer(1, "fsn1", "203.0.113.1", false)
+	attached.PrivateNet = []hc.ServerPrivateNet{{Network: &hc.Network{ID: 2}, IP: net.ParseIP("10.1.0.1")}}
+	other := fakeServer(2, "fsn1", "203.0.113.2", false)
+	other.PrivateNet = []hc.ServerPrivateNet{{Network: &hc.Network{ID: 1}, IP: net.ParseIP("10.0.0.2")}}
+	detached := fakeServer(3, "fsn1", "203.0.113.3", false)
+	p := &hcloud.Provider{
+		NewServerAPI: func(apiToken string) hcloud.ServerAPI {
+			return &fakeServerAPI{servers: []*hc.Server{attached, other, detached}}
+		},
+		NewNetworkAPI: func(apiToken string) hcloud.NetworkAPI {
+			return fakeNetworkAPI{{ID: 1, Name: "frontend"}, {ID: 2, Name: "backend"}}
+		},
+	}
+
+	tests := []struct {
+		name  string
+		args  discover.Config
+		addrs []string
+		err   string
+	}{
+		{
+			"network name, servers outside the network are skipped",
+			discover.Config{"network": "backend"},
+			[]string{"10.1.0.1"},
+			"",
+		},
+		{
+			"network by id",
+			discover.Config{"network": "2"},
+			[]string{"10.1.0.1"},
+			"",
+		},
+		{
+			"network id",
+			discover.Config{"network_id": "2", "address_type": "public_v4"},
+			[]string{"203.0.113.1"},
+			"",
+		},
+		{
+			"require network",
+			discover.Config{"network": "backend", "require_network": "true"},
+			nil,
+			"discover-hcloud: servers not attached to network 2: node-2 (2), node-3 (3)",
+		},
+		{
+			"unknown network name",
+			discover.Config{"network": "storage"},
+			nil,
+			"discover-hcloud: network storage not found",
+		},
+		{
+			"unknown network id",
+			discover.Config{"network_id": "9"},
+			nil,
+			"discover-hcloud: network 9 not found",
+		},
+		{
+			"unknown network by id",
+			discover.Config{"network": "9"},
+			nil,
+			"discover-hcloud: network 9 not found",
+		},
+	}
+
+	l := log.New(ioutil.Discard, "", 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.args["provider"] = "hcloud"
+			tt.args["api_token"] = "test"
+			tt.args["location"] = "fsn1"
+			addrs, err := p.Addrs(tt.args, l)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(addrs, tt.addrs) {
+				t.Fatalf("got %v want %v", addrs, tt.addrs)
+			}
+		})
+	}
+
+	// Ping looks up the network with the same API
+	for _, network := range []string{"backend", "2", "storage"} {
+		args := discover.Config{"provider": "hcloud", "api_token": "test", "network": network}
+		err := p.Ping(context.Background(), args, l)
+		if network == "storage" {
+			if want := "discover-hcloud: network storage not found"; err == nil || err.Error() != want {
+				t.Fatalf("%s: got error %v want %s", network, err, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", network, err)
+		}
+	}
+}
+
+// fakeCertificateAPI returns canned certificates and load balancers instead
+// of calling the hcloud API.
+type fakeCertificateAPI struct {
+	certs []*hc.Certificate
+	lbs   []*hc.LoadBalancer
+}
+
+func (f *fakeCertificateAPI) Get(ctx context.Context, idOrName string) (*hc.Certificate, *hc.Response, error) {
+	for _, c := range f.certs {
+		if c.Name == idOrName || strconv.Itoa(c.ID) == idOrName {
+			return c, nil, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+func (f *fakeCertificateAPI) GetByID(ctx context.Context, id int) (*hc.LoadBalancer, *hc.Response, error) {
+	for _, lb := range f.lbs {
+		if lb.ID == id {
+			return lb, nil, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+func TestAddrsCertificate(t *testing.T) {
+	target := func(id int) hc.LoadBalancerTarget {
+		return hc.LoadBalancerTarget{Type: hc.LoadBalancerTargetTypeServer, Server: &hc.LoadBalancerTargetServer{Server: &hc.Server{ID: id}}}
+	}
+	api := &fakeCertificateAPI{
+		certs: []*hc.Certificate{
+			{ID: 5, Name: "web", UsedBy: []hc.CertificateUsedByRef{
+				{ID: 10, Type: hc.CertificateUsedByRefTypeLoadBalancer},
+				{ID: 11, Type: hc.CertificateUsedByRefTypeLoadBalancer},
+			}},
+			{ID: 6, Name: "unused"},
+		},
+		lbs: []*hc.LoadBalancer{
+			{ID: 10, Name: "web-1", Targets: []hc.LoadBalancerTarget{target(1)}},
+			{ID: 11, Name: "web-2", Targets: []hc.LoadBalancerTarget{
+				{Type: hc.LoadBalancerTargetTypeLabelSelector, Targets: []hc.LoadBalancerTarget{target(2)}},
+			}},
+		},
+	}
+	p := &hcloud.Provider{
+		NewServerAPI: func(apiToken string) hcloud.ServerAPI {
+			return &fakeServerAPI{servers: []*hc.Server{
+				fakeServer(1, "fsn1", "203.0.113.1", false, "10.0.0.1"),
+				fakeServer(2, "fsn1", "203.0.113.2", false, "10.0.0.2"),
+				fakeServer(3, "fsn1", "203.0.113.3", false, "10.0.0.3"),
+			}}
+		},
+		NewCertificateAPI:  func(apiToken string) hcloud.CertificateAPI { return api },
+		NewLoadBalancerAPI: func(apiToken string) hcloud.LoadBalancerAPI { return api },
+	}
+
+	tests := []struct {
+		certificate string
+		addrs       []string
+		err         string
+	}{
+		{"web", []string{"10.0.0.1", "10.0.0.2"}, ""},
+		{"5", []string{"10.0.0.1", "10.0.0.2"}, ""},
+		{"unused", nil, ""},
+		{"api", nil, "discover-hcloud: certificate api not found"},
+	}
+
+	l := log.New(ioutil.Discard, "", 0)
+	for _, tt := range tests {
+		args := discover.Config{"provider": "hcloud", "api_token": "test", "location": "fsn1", "certificate": tt.certificate}
+		addrs, err := p.Addrs(args, l)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("%s: got error %v want %s", tt.certificate, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(addrs, tt.addrs) {
+			t.Fatalf("%s: got %v want %v", tt.certificate, addrs, tt.addrs)
+		}
+	}
+}
+
+func TestAddrsPagesAndFloatingIPs(t *testing.T) {
+	server := func(id int, floatingIPs string) string {
+		return fmt.Sprintf(`{"id": %d, "name": "node-%d", "status": "running",
+			"public_net": {"ipv4": {"ip": "203.0.113.%d", "blocked": true}, "ipv6": {"ip": "2001:db8:%d::/64"}, "floating_ips": [%s]},
+			"datacenter": {"name": "fsn1-dc14", "location": {"name": "fsn1"}}}`, id, id, id, id, floatingIPs)
+	}
+	pages := map[string]string{
+		"1": `{"servers": [` + server(1, "7") + `], "meta": {"pagination": {"page": 1, "per_page": 50, "next_page": 2, "last_page": 2, "total_entries": 2}}}`,
+		"2": `{"servers": [` + server(2, "") + `], "meta": {"pagination": {"page": 2, "per_page": 50, "previous_page": 1, "last_page": 2, "total_entries": 2}}}`,
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/servers":
+			page, ok := pages[r.URL.Query().Get("page")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, page)
+		case "/floating_ips":
+			fmt.Fprint(w, `{"floating_ips": [{"id": 7, "type": "ipv4", "ip": "198.51.100.7", "blocked": false, "server": 1}],
+				"meta": {"pagination": {"page": 1, "per_page": 50, "last_page": 1, "total_entries": 1}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p := &hcloud.Provider{}
+	args := discover.Config{
+		"provider":     "hcloud",
+		"api_token":    "test",
+		"endpoint":     srv.URL,
+		"location":     "fsn1",
+		"address_type": "floating_v4",
+		"concurrency":  "2",
+	}
+	addrs, err := p.Addrs(args, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"198.51.100.7"}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v want %v", addrs, want)
+	}
+
+	// the blocked public IPv4 falls back to the floating IP
+	args["address_type"] = "public_v4"
+	addrs, err = p.Addrs(args, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"198.51.100.7"}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v want %v", addrs, want)
+	}
+
+	args["concurrency"] = "0"
+	if _, err := p.Addrs(args, nil); err == nil {
+		t.Fatal("expected error for invalid concurrency")
+	}
+}
+
+// fakeFloatingIPAPI returns canned floating IPs instead of calling the hcloud
+// API.
+type fakeFloatingIPAPI []*hc.FloatingIP
+
+func (f fakeFloatingIPAPI) List(ctx context.Context, opts hc.FloatingIPListOpts) ([]*hc.FloatingIP, *hc.Response, error) {
+	return f, nil, nil
+}
+
+func TestAddrsFloatingIPsFake(t *testing.T) {
+	// the server API only returns the IDs of the floating IPs
+	assigned := fakeServer(1, "fsn1", "203.0.113.1", false)
+	assigned.PublicNet.FloatingIPs = []*hc.FloatingIP{{ID: 7}, {ID: 8}, {ID: 9}}
+	unassigned := fakeServer(2, "fsn1", "203.0.113.2", false)
+	p := &hcloud.Provider{
+		NewServerAPI: func(apiToken string) hcloud.ServerAPI {
+			return &fakeServerAPI{servers: []*hc.Server{assigned, unassigned}}
+		},
+		NewFloatingIPAPI: func(apiToken string) hcloud.FloatingIPAPI {
+			return fakeFloatingIPAPI{
+				{ID: 7, Type: hc.FloatingIPTypeIPv4, IP: net.ParseIP("198.51.100.7"), Blocked: true},
+				{ID: 8, Type: hc.FloatingIPTypeIPv6, IP: net.ParseIP("2001:db8:8::1")},
+				{ID: 9, Type: hc.FloatingIPTypeIPv4, IP: net.ParseIP("198.51.100.9")},
+			}
+		},
+	}
+
+	tests := []struct {
+		addressType string
+		addrs       []string
+	}{
+		{"floating_v4", []string{"198.51.100.9"}},
+		{"floating_v6", []string{"2001:db8:8::1"}},
+	}
+	for _, tt := range tests {
+		args := discover.Config{"provider": "hcloud", "api_token": "test", "location": "fsn1", "address_type": tt.addressType}
+		addrs, err := p.Addrs(args, log.New(ioutil.Discard, "", 0))
+		if err != nil {
+			t.Fatalf("%s: %s", tt.addressType, err)
+		}
+		if !reflect.DeepEqual(addrs, tt.addrs) {
+			t.Fatalf("%s: got %v want %v", tt.addressType, addrs, tt.addrs)
+		}
+	}
+}
+
+func TestPingFake(t *testing.T) {
+	p := &hcloud.Provider{
+		NewServerAPI: func(apiToken string) hcloud.ServerAPI { return &fakeServerAPI{} },
+	}
+	args := discover.Config{"provider": "hcloud", "api_token": "test"}
+	if err := p.Ping(context.Background(), args, log.New(ioutil.Discard, "", 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	p.NewServerAPI = func(apiToken string) hcloud.ServerAPI { return &blockingServerAPI{} }
+	args["timeout"] = "50ms"
+	err := p.Ping(context.Background(), args, log.New(ioutil.Discard, "", 0))
+	if want := "discover-hcloud: timed out after 50ms"; err == nil || err.Error() != want {
+		t.Fatalf("got error %v want %s", err, want)
+	}
+}
+
+// TestAddrsUnion runs the same provider for several configs concurrently.
+// Run it with -race to check that no lookup state is shared between them.
+func TestAddrsUnion(t *testing.T) {
+	api := &fakeServerAPI{
+		servers: []*hc.Server{
+			fakeServer(1, "fsn1", "203.0.113.1", false, "10.0.0.1"),
+			fakeServer(2, "nbg1", "203.0.113.2", false, "10.0.0.2"),
+		},
+	}
+	p := &hcloud.Provider{
+		NewServerAPI: func(apiToken string) hcloud.ServerAPI { return api },
+	}
+	d := discover.Discover{
+		Providers:   map[string]discover.Provider{"hcloud": p},
+		Concurrency: 2,
+	}
+
+	cfg := "provider=hcloud api_token=a location=fsn1 concurrency=1 + provider=hcloud api_token=b location=nbg1"
+	for i := 0; i < 10; i++ {
+		addrs, err := d.Addrs(cfg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"10.0.0.1", "10.0.0.2"}; !reflect.DeepEqual(addrs, want) {
+			t.Fatalf("got %v want %v", addrs, want)
+		}
+	}
+}
+
+// blockingServerAPI blocks every call until ctx is done.
+type blockingServerAPI struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (b *blockingServerAPI) block(ctx context.Context) error {
+	b.mu.Lock()
+	b.calls++
+	b.mu.Unlock()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (b *blockingServerAPI) GetByID(ctx context.Context, id int) (*hc.Server, *hc.Response, error) {
+	return nil, nil, b.block(ctx)
+}
+
+func (b *blockingServerAPI) GetByName(ctx context.Context, name string) (*hc.Server, *hc.Response, error) {
+	return nil, nil, b.block(ctx)
+}
+
+func (b *blockingServerAPI) AllWithOpts(ctx context.Context, opts hc.ServerListOpts) ([]*hc.Server, error) {
+	return nil, b.block(ctx)
+}
+
+func TestAddrsTimeout(t *testing.T) {
+	api := &blockingServerAPI{}
+	p := &hcloud.Provider{
+		NewServerAPI: func(apiToken string) hcloud.ServerAPI { return api },
+	}
+
+	args := discover.Config{"provider": "hcloud", "api_token": "test", "location": "fsn1", "timeout": "50ms"}
+	_, err := p.Addrs(args, log.New(ioutil.Discard, "", 0))
+	if want := "discover-hcloud: timed out after 50ms"; err == nil || err.Error() != want {
+		t.Fatalf("got error %v want %s", err, want)
+	}
+	if p.Retryable(err) {
+		t.Fatal("timeout is retryable")
+	}
+
+	// the core retry does not repeat the timed out lookup
+	api.calls = 0
+	d := discover.Discover{Providers: map[string]discover.Provider{"hcloud": p}}
+	if _, err := d.Addrs(args.String()+" retry_max=3 retry_wait_min=1ms", nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if api.calls != 1 {
+		t.Fatalf("got %d calls want 1", api.calls)
+	}
+}
+
+// selectorServerAPI records the label selector of the server list.
+type selectorServerAPI struct {
+	fakeServerAPI
+	selector string
+}
+
+func (s *selectorServerAPI) AllWithOpts(ctx context.Context, opts hc.ServerListOpts) ([]*hc.Server, error) {
+	s.selector = opts.LabelSelector
+	return nil, nil
+}
+
+func TestAddrsCreatedBy(t *testing.T) {
+	tests := []struct {
+		args     discover.Config
+		selector string
+		err      string
+	}{
+		{discover.Config{"created_by": "terraform"}, "created-by=terraform", ""},
+		{discover.Config{"created_by": "terraform", "label_selector": "env=prod,role in (server)"}, "env=prod,role in (server),created-by=terraform", ""},
+		{discover.Config{"created_by": "ci", "created_by_label": "managed-by"}, "managed-by=ci", ""},
+		{discover.Config{"created_by_label": "managed-by", "label_selector": "env=prod"}, "env=prod", ""},
+		{discover.Config{"created_by": "a,b"}, "", "discover-hcloud: invalid created_by created-by=a,b"},
+		{discover.Config{"created_by": "a=b"}, "", "discover-hcloud: invalid created_by created-by=a=b"},
+		{discover.Config{"created_by": "!a"}, "", "discover-hcloud: invalid created_by created-by=!a"},
+		{discover.Config{"created_by": "a b"}, "", "discover-hcloud: invalid created_by created-by=a b"},
+		{discover.Config{"created_by": "(a)"}, "", "discover-hcloud: invalid created_by created-by=(a)"},
+		{discover.Config{"created_by": "ci", "created_by_label": "managed by"}, "", "discover-hcloud: invalid created_by managed by=ci"},
+	}
+
+	l := log.New(ioutil.Discard, "", 0)
+	for _, tt := range tests {
+		api := &selectorServerAPI{}
+		p := &hcloud.Provider{
+			NewServerAPI: func(apiToken string) hcloud.ServerAPI { return api },
+		}
+		args := discover.Config{"provider": "hcloud", "api_token": "test", "location": "fsn1"}
+		for k, v := range tt.args {
+			args[k] = v
+		}
+		_, err := p.Addrs(args, l)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("%v: got error %v want %s", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %s", tt.args, err)
+		}
+		if api.selector != tt.selector {
+			t.Fatalf("%v: got label selector %q want %q", tt.args, api.selector, tt.selector)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package hcloud
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// serverFilter is a predicate a server has to satisfy to be discovered.
+type serverFilter struct {
+	// name describes the filter in log messages.
+	name string
+
+	// match reports whether the server satisfies the filter.
+	match func(s *hcloud.Server) bool
+}
+
+// filterServers returns the servers which satisfy all filters. For every
+// excluded server the first filter it failed is logged, followed by the
+// number of servers each filter excluded.
+func filterServers(servers []*hcloud.Server, filters []serverFilter, l *log.Logger) []*hcloud.Server {
+	var matched []*hcloud.Server
+	excluded := make([]int, len(filters))
+	for _, s := range servers {
+		ok := true
+		for i, f := range filters {
+			if !f.match(s) {
+				l.Printf("[DEBUG] discover-hcloud: instance %s (%d) excluded by %s", s.Name, s.ID, f.name)
+				excluded[i]++
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, s)
+		}
+	}
+	for i, f := range filters {
+		if excluded[i] != 0 {
+			l.Printf("[INFO] discover-hcloud: %d instance(s) excluded by %s", excluded[i], f.name)
+		}
+	}
+	return matched
+}
+
+// locationFilter matches servers in any of the given locations.
+func locationFilter(locations []string) serverFilter {
+	set := map[string]bool{}
+	for _, location := range locations {
+		set[location] = true
+	}
+	return serverFilter{
+		name: "location " + strings.Join(locations, ","),
+		match: func(s *hcloud.Server) bool {
+			return s.Datacenter != nil && s.Datacenter.Location != nil && set[s.Datacenter.Location.Name]
+		},
+	}
+}
+
+// serverIDFilter matches the servers with the given IDs.
+func serverIDFilter(name string, ids map[int]bool) serverFilter {
+	return serverFilter{
+		name: name,
+		match: func(s *hcloud.Server) bool {
+			return ids[s.ID]
+		},
+	}
+}
+
+// labelFilter matches servers whose labels match the label selector. The
+// hcloud API already filters by the label selector but checking it again
+// makes the combination with the other filters explicit in the logs.
+func labelFilter(selector string) (serverFilter, error) {
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return serverFilter{}, err
+	}
+	return serverFilter{
+		name: "label_selector " + selector,
+		match: func(s *hcloud.Server) bool {
+			return sel.Matches(labels.Set(s.Labels))
+		},
+	}, nil
+}
+
+// serverTypeFilter matches servers of the given server type, ignoring case.
+func serverTypeFilter(serverType string) serverFilter {
+	return serverFilter{
+		name: "server_type " + serverType,
+		match: func(s *hcloud.Server) bool {
+			return s.ServerType != nil && strings.EqualFold(s.ServerType.Name, serverType)
+		},
+	}
+}
+
+// imageFilter matches servers created from the image with the given name,
+// ignoring case.
+func imageFilter(image string) serverFilter {
+	return serverFilter{
+		name: "image " + image,
+		match: func(s *hcloud.Server) bool {
+			return s.Image != nil && strings.EqualFold(s.Image.Name, image)
+		},
+	}
+}
+
+// placementGroupFilter matches the members of the placement group with the
+// given name or ID.
+func placementGroupFilter(placementGroup string) serverFilter {
+	return serverFilter{
+		name: "placement_group " + placementGroup,
+		match: func(s *hcloud.Server) bool {
+			pg := s.PlacementGroup
+			return pg != nil && (pg.Name == placementGroup || strconv.Itoa(pg.ID) == placementGroup)
+		},
+	}
+}
+
+// subnetFilter matches servers which have a private IP address in the given
+// subnet.
+func subnetFilter(subnet string) (serverFilter, error) {
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return serverFilter{}, fmt.Errorf("invalid subnet %q", subnet)
+	}
+	return serverFilter{
+		name: "subnet " + ipnet.String(),
+		match: func(s *hcloud.Server) bool {
+			for _, privateNet := range s.PrivateNet {
+				if ipnet.Contains(privateNet.IP) {
+					return true
+				}
+			}
+			return false
+		},
+	}, nil
+}
+
+// sortOtherPlacementGroupsFirst moves the servers in the placement group with
+// the given ID to the end and keeps the order otherwise.
+func sortOtherPlacementGroupsFirst(servers []*hcloud.Server, placementGroupID int) []*hcloud.Server {
+	sorted := make([]*hcloud.Server, len(servers))
+	copy(sorted, servers)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return !inPlacementGroup(sorted[i], placementGroupID) && inPlacementGroup(sorted[j], placementGroupID)
+	})
+	return sorted
+}
+
+func inPlacementGroup(s *hcloud.Server, placementGroupID int) bool {
+	return s.PlacementGroup != nil && s.PlacementGroup.ID == placementGroupID
+}
@@ -0,0 +1,224 @@
+package hcloud
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+func TestFilterServersLabelAndSubnet(t *testing.T) {
+	server := func(id int, role, ip string) *hcloud.Server {
+		return &hcloud.Server{
+			ID:         id,
+			Name:       role,
+			Labels:     map[string]string{"role": role},
+			PrivateNet: []hcloud.ServerPrivateNet{{IP: net.ParseIP(ip)}},
+		}
+	}
+	servers := []*hcloud.Server{
+		server(1, "database", "10.0.1.10"),                                       // both
+		server(2, "database", "10.0.2.10"),                                       // label only
+		server(3, "web", "10.0.1.11"),                                            // subnet only
+		server(4, "web", "10.0.2.11"),                                            // neither
+		{ID: 5, Name: "database", Labels: map[string]string{"role": "database"}}, // no private net
+	}
+
+	label, err := labelFilter("role=database")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnet, err := subnetFilter("10.0.1.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		filters []serverFilter
+		ids     []int
+	}{
+		{"none", nil, []int{1, 2, 3, 4, 5}},
+		{"label", []serverFilter{label}, []int{1, 2, 5}},
+		{"subnet", []serverFilter{subnet}, []int{1, 3}},
+		{"label and subnet", []serverFilter{label, subnet}, []int{1}},
+		{"subnet and label", []serverFilter{subnet, label}, []int{1}},
+	}
+
+	l := log.New(ioutil.Discard, "", 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []int
+			for _, s := range filterServers(servers, tt.filters, l) {
+				ids = append(ids, s.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.ids) {
+				t.Fatalf("got %v want %v", ids, tt.ids)
+			}
+		})
+	}
+}
+
+func TestFilterServersLogsExclusion(t *testing.T) {
+	label, _ := labelFilter("role=database")
+	subnet, _ := subnetFilter("10.0.1.0/24")
+	servers := []*hcloud.Server{{
+		ID:         2,
+		Name:       "db-2",
+		Labels:     map[string]string{"role": "database"},
+		PrivateNet: []hcloud.ServerPrivateNet{{IP: net.ParseIP("10.0.2.10")}},
+	}}
+
+	var buf bytes.Buffer
+	filterServers(servers, []serverFilter{label, subnet}, log.New(&buf, "", 0))
+	want := "[DEBUG] discover-hcloud: instance db-2 (2) excluded by subnet 10.0.1.0/24\n" +
+		"[INFO] discover-hcloud: 1 instance(s) excluded by subnet 10.0.1.0/24\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestSubnetFilterInvalid(t *testing.T) {
+	if _, err := subnetFilter("10.0.1.0"); err == nil {
+		t.Fatal("expected error for subnet without prefix length")
+	}
+}
+
+func TestSortOtherPlacementGroupsFirst(t *testing.T) {
+	pg := func(id int) *hcloud.PlacementGroup { return &hcloud.PlacementGroup{ID: id} }
+	servers := []*hcloud.Server{
+		{ID: 1, PlacementGroup: pg(10)},
+		{ID: 2, PlacementGroup: pg(20)},
+		{ID: 3},
+		{ID: 4, PlacementGroup: pg(10)},
+		{ID: 5, PlacementGroup: pg(30)},
+	}
+
+	var ids []int
+	for _, s := range sortOtherPlacementGroupsFirst(servers, 10) {
+		ids = append(ids, s.ID)
+	}
+	if want := []int{2, 3, 5, 1, 4}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("got %v want %v", ids, want)
+	}
+	if servers[0].ID != 1 {
+		t.Fatal("input slice was modified")
+	}
+}
+
+func TestLocationFilter(t *testing.T) {
+	server := func(id int, location string) *hcloud.Server {
+		return &hcloud.Server{
+			ID:         id,
+			Datacenter: &hcloud.Datacenter{Location: &hcloud.Location{Name: location}},
+		}
+	}
+	servers := []*hcloud.Server{server(1, "fsn1"), server(2, "nbg1"), server(3, "hel1"), {ID: 4}}
+
+	tests := []struct {
+		location string
+		ids      []int
+	}{
+		{"fsn1", []int{1}},
+		{"fsn1,nbg1", []int{1, 2}},
+		{" fsn1, ,hel1 ", []int{1, 3}},
+		{"ash", nil},
+	}
+
+	l := log.New(ioutil.Discard, "", 0)
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			var ids []int
+			f := locationFilter(splitList(tt.location))
+			for _, s := range filterServers(servers, []serverFilter{f}, l) {
+				ids = append(ids, s.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.ids) {
+				t.Fatalf("got %v want %v", ids, tt.ids)
+			}
+		})
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		in   string
+		list []string
+	}{
+		{"", nil},
+		{" , ,", nil},
+		{"fsn1", []string{"fsn1"}},
+		{"fsn1, nbg1,,hel1 ", []string{"fsn1", "nbg1", "hel1"}},
+	}
+
+	for _, tt := range tests {
+		if got, want := splitList(tt.in), tt.list; !reflect.DeepEqual(got, want) {
+			t.Fatalf("%q: got %v want %v", tt.in, got, want)
+		}
+	}
+}
+
+func TestServerTypeAndImageFilter(t *testing.T) {
+	server := func(id int, serverType, image string) *hcloud.Server {
+		s := &hcloud.Server{ID: id, ServerType: &hcloud.ServerType{Name: serverType}}
+		if image != "" {
+			s.Image = &hcloud.Image{Name: image}
+		}
+		return s
+	}
+	servers := []*hcloud.Server{
+		server(1, "cx21", "ubuntu-22.04"),
+		server(2, "CX21", "debian-11"),
+		server(3, "cx31", "ubuntu-22.04"),
+		server(4, "cx21", ""), // created from a snapshot without a name
+		{ID: 5},
+	}
+
+	tests := []struct {
+		name    string
+		filters []serverFilter
+		ids     []int
+	}{
+		{"server_type", []serverFilter{serverTypeFilter("cx21")}, []int{1, 2, 4}},
+		{"server_type case", []serverFilter{serverTypeFilter("Cx21")}, []int{1, 2, 4}},
+		{"server_type exact", []serverFilter{serverTypeFilter("cx2")}, nil},
+		{"image", []serverFilter{imageFilter("UBUNTU-22.04")}, []int{1, 3}},
+		{"both", []serverFilter{serverTypeFilter("cx21"), imageFilter("ubuntu-22.04")}, []int{1}},
+	}
+
+	l := log.New(ioutil.Discard, "", 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []int
+			for _, s := range filterServers(servers, tt.filters, l) {
+				ids = append(ids, s.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.ids) {
+				t.Fatalf("got %v want %v", ids, tt.ids)
+			}
+		})
+	}
+}
+
+func TestPlacementGroupFilter(t *testing.T) {
+	servers := []*hcloud.Server{
+		{ID: 1, PlacementGroup: &hcloud.PlacementGroup{ID: 10, Name: "consul"}},
+		{ID: 2, PlacementGroup: &hcloud.PlacementGroup{ID: 20, Name: "nomad"}},
+		{ID: 3},
+	}
+
+	l := log.New(ioutil.Discard, "", 0)
+	for _, pg := range []string{"consul", "10"} {
+		var ids []int
+		for _, s := range filterServers(servers, []serverFilter{placementGroupFilter(pg)}, l) {
+			ids = append(ids, s.ID)
+		}
+		if want := []int{1}; !reflect.DeepEqual(ids, want) {
+			t.Fatalf("%s: got %v want %v", pg, ids, want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package hcloud
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+func TestServerIPs(t *testing.T) {
+	s := &hcloud.Server{
+		ID:   1,
+		Name: "node",
+		PublicNet: hcloud.ServerPublicNet{
+			IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("203.0.113.1")},
+			IPv6: hcloud.ServerPublicNetIPv6{IP: net.ParseIP("2001:db8::1")},
+		},
+		PrivateNet: []hcloud.ServerPrivateNet{{IP: net.ParseIP("10.0.0.1")}},
+	}
+	blocked := &hcloud.Server{
+		ID:   2,
+		Name: "blocked",
+		PublicNet: hcloud.ServerPublicNet{
+			IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("203.0.113.2"), Blocked: true},
+			IPv6: hcloud.ServerPublicNetIPv6{IP: net.ParseIP("2001:db8::2")},
+			FloatingIPs: []*hcloud.FloatingIP{
+				{Type: hcloud.FloatingIPTypeIPv4, IP: net.ParseIP("198.51.100.2")},
+			},
+		},
+	}
+	floating := &hcloud.Server{
+		ID:   3,
+		Name: "floating",
+		PublicNet: hcloud.ServerPublicNet{
+			IPv4: hcloud.ServerPublicNetIPv4{IP: net.ParseIP("203.0.113.3")},
+			IPv6: hcloud.ServerPublicNetIPv6{IP: net.ParseIP("2001:db8::3")},
+			FloatingIPs: []*hcloud.FloatingIP{
+				{Type: hcloud.FloatingIPTypeIPv4, IP: net.ParseIP("198.51.100.3"), Blocked: true},
+				{Type: hcloud.FloatingIPTypeIPv6, IP: net.ParseIP("2001:db8:2::3")},
+				{Type: hcloud.FloatingIPTypeIPv4, IP: net.ParseIP("198.51.100.4")},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		server   *hcloud.Server
+		addrType string
+		ips      []string
+	}{
+		{"private", s, "private_v4", []string{"10.0.0.1"}},
+		{"public v4", s, "public_v4", []string{"203.0.113.1"}},
+		{"public v6", s, "public_v6", []string{"2001:db8::1"}},
+		{"public dual", s, "public_dual", []string{"203.0.113.1", "2001:db8::1"}},
+		{"all", s, "all", []string{"10.0.0.1", "203.0.113.1", "2001:db8::1"}},
+		{"public dual floating fallback", blocked, "public_dual", []string{"198.51.100.2", "2001:db8::2"}},
+		{"all without private", blocked, "all", []string{"198.51.100.2", "2001:db8::2"}},
+		{"auto private", s, "auto", []string{"10.0.0.1"}},
+		{"auto falls back to public", blocked, "auto", []string{"198.51.100.2"}},
+		{"floating v4", floating, "floating_v4", []string{"198.51.100.4"}},
+		{"floating v6", floating, "floating_v6", []string{"2001:db8:2::3"}},
+		{"floating v4 blocked primary", blocked, "floating_v4", []string{"198.51.100.2"}},
+		{"no floating v6", blocked, "floating_v6", nil},
+		{"no floating", s, "floating_v4", nil},
+	}
+
+	l := log.New(ioutil.Discard, "", 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := serverIPs(tt.server, tt.addrType, 0, l), tt.ips; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestReadAPIToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hcloud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(file, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	defer os.Setenv("HCLOUD_TOKEN", os.Getenv("HCLOUD_TOKEN"))
+	defer os.Setenv("HCLOUD_TOKEN_FILE", os.Getenv("HCLOUD_TOKEN_FILE"))
+
+	tests := []struct {
+		name      string
+		args      map[string]string
+		env       string
+		envFile   string
+		token     string
+		errPrefix string
+	}{
+		{"api_token wins", map[string]string{"api_token": "arg-token", "token_file": file}, "env-token", "", "arg-token", ""},
+		{"token_file before env", map[string]string{"token_file": file}, "env-token", "", "file-token", ""},
+		{"token file from env", nil, "env-token", file, "file-token", ""},
+		{"env token", nil, "env-token", "", "env-token", ""},
+		{"missing file", map[string]string{"token_file": missing}, "env-token", "", "", "discover-hcloud: reading token_file: "},
+		{"no token", nil, "", "", "", "discover-hcloud: no API token specified"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("HCLOUD_TOKEN", tt.env)
+			os.Setenv("HCLOUD_TOKEN_FILE", tt.envFile)
+			token, err := readAPIToken(tt.args)
+			if tt.errPrefix != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.errPrefix) {
+					t.Fatalf("got error %v want prefix %q", err, tt.errPrefix)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != tt.token {
+				t.Fatalf("got token %q want %q", token, tt.token)
+			}
+		})
+	}
+}
+
+func TestReadEndpoint(t *testing.T) {
+	defer os.Setenv("HCLOUD_ENDPOINT", os.Getenv("HCLOUD_ENDPOINT"))
+
+	tests := []struct {
+		name     string
+		args     map[string]string
+		env      string
+		endpoint string
+		err      bool
+	}{
+		{"default", nil, "", "", false},
+		{"arg", map[string]string{"endpoint": "https://proxy.example.com/v1"}, "http://env.example.com", "https://proxy.example.com/v1", false},
+		{"env", nil, "http://localhost:8080/v1", "http://localhost:8080/v1", false},
+		{"no scheme", map[string]string{"endpoint": "proxy.example.com/v1"}, "", "", true},
+		{"wrong scheme", map[string]string{"endpoint": "ftp://proxy.example.com"}, "", "", true},
+		{"unparsable", map[string]string{"endpoint": "http://[::1"}, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("HCLOUD_ENDPOINT", tt.env)
+			endpoint, err := readEndpoint(tt.args)
+			if tt.err {
+				if err == nil || !strings.HasPrefix(err.Error(), "discover-hcloud: invalid endpoint") {
+					t.Fatalf("got error %v want invalid endpoint", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if endpoint != tt.endpoint {
+				t.Fatalf("got endpoint %q want %q", endpoint, tt.endpoint)
+			}
+		})
+	}
+}
+
+func TestPingEndpoint(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"servers": []}`))
+	}))
+	defer srv.Close()
+
+	p := &Provider{}
+	args := map[string]string{"provider": "hcloud", "api_token": "token", "endpoint": srv.URL + "/v1"}
+	if err := p.Ping(context.Background(), args, log.New(ioutil.Discard, "", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := path, "/v1/servers"; got != want {
+		t.Fatalf("got path %q want %q", got, want)
+	}
+}
+
+func TestPingInvalidArgs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("API called for %s", r.URL)
+	}))
+	defer srv.Close()
+
+	tests := []map[string]string{
+		{"statuses": "stopped"},
+		{"status": "running", "statuses": "running"},
+		{"timeout": "soon"},
+		{"address_type": "private"},
+		{"network_id": "backend"},
+		{"network": "backend", "network_id": "2"},
+		{"require_network": "maybe"},
+		{"hostname_fallback": "maybe"},
+		{"prefer_other_placement_group": "maybe"},
+		{"subnet": "10.0.0.0"},
+		{"created_by": "terraform", "created_by_label": "created by"},
+	}
+	p := &Provider{}
+	for _, args := range tests {
+		args["provider"] = "hcloud"
+		args["api_token"] = "token"
+		args["endpoint"] = srv.URL
+		if err := p.Ping(context.Background(), args, log.New(ioutil.Discard, "", 0)); err == nil {
+			t.Fatalf("%v: expected error", args)
+		}
+	}
+}
+
+// idServerAPI looks up servers by ID only.
+type idServerAPI map[int]*hcloud.Server
+
+func (a idServerAPI) GetByID(ctx context.Context, id int) (*hcloud.Server, *hcloud.Response, error) {
+	return a[id], nil, nil
+}
+
+func (a idServerAPI) GetByName(ctx context.Context, name string) (*hcloud.Server, *hcloud.Response, error) {
+	return nil, nil, fmt.Errorf("unexpected lookup by name %s", name)
+}
+
+func (a idServerAPI) AllWithOpts(ctx context.Context, opts hcloud.ServerListOpts) ([]*hcloud.Server, error) {
+	return nil, nil
+}
+
+func TestCurrentServerMetadata(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/instance-id" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("42"))
+	}))
+	defer srv.Close()
+	defer func(endpoint string) { metadataEndpoint = endpoint }(metadataEndpoint)
+	metadataEndpoint = srv.URL
+
+	api := idServerAPI{42: {ID: 42, Name: "renamed"}}
+	server, err := currentServer(context.Background(), api, true, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server == nil || server.ID != 42 {
+		t.Fatalf("got server %v want id 42", server)
+	}
+}
+
+func TestMetadataInstanceIDTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+	defer func(endpoint string) { metadataEndpoint = endpoint }(metadataEndpoint)
+	metadataEndpoint = srv.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := metadataInstanceID(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("metadata lookup took %s despite timeout", d)
+	}
+}
+
+func TestCurrentServerNoHostnameFallback(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	defer func(endpoint string) { metadataEndpoint = endpoint }(metadataEndpoint)
+	metadataEndpoint = srv.URL
+
+	// idServerAPI fails lookups by name, so a fallback would be an error
+	server, err := currentServer(context.Background(), idServerAPI{}, false, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server != nil {
+		t.Fatalf("got server %v want nil", server)
+	}
+}
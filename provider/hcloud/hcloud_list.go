@@ -0,0 +1,154 @@
+package hcloud
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/hashicorp/go-discover/provider"
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+// maxPerPage is the maximum page size of the hcloud API.
+const maxPerPage = 50
+
+// serverLister is implemented by server APIs which can list single pages of
+// servers, like *hcloud.ServerClient. Their pages are fetched concurrently.
+type serverLister interface {
+	List(ctx context.Context, opts hcloud.ServerListOpts) ([]*hcloud.Server, *hcloud.Response, error)
+}
+
+// listPages calls list for the first page and then for all remaining pages
+// with at most concurrency calls at the same time. Every call is retried.
+func listPages(ctx context.Context, op string, concurrency int, l *log.Logger, list func(page int) (*hcloud.Response, error)) error {
+	var resp *hcloud.Response
+	err := retry(ctx, op, l, func() error {
+		var err error
+		resp, err = list(1)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	lastPage := 1
+	if resp != nil && resp.Meta.Pagination != nil {
+		lastPage = resp.Meta.Pagination.LastPage
+	}
+	if lastPage <= 1 {
+		return nil
+	}
+
+	l.Printf("[DEBUG] discover-hcloud: %s: fetching %d more pages with concurrency %d", op, lastPage-1, concurrency)
+	errs := make([]error, lastPage-1)
+	provider.ForEach(lastPage-1, concurrency, func(i int) {
+		errs[i] = retry(ctx, op, l, func() error {
+			_, err := list(i + 2)
+			return err
+		})
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listServers returns all servers matching opts. If the server API can list
+// single pages they are fetched with at most concurrency requests at the
+// same time.
+func listServers(ctx context.Context, serverAPI ServerAPI, opts hcloud.ServerListOpts, concurrency int, l *log.Logger) ([]*hcloud.Server, error) {
+	lister, ok := serverAPI.(serverLister)
+	if !ok {
+		var servers []*hcloud.Server
+		err := retry(ctx, "listing servers", l, func() error {
+			var err error
+			servers, err = serverAPI.AllWithOpts(ctx, opts)
+			return err
+		})
+		return servers, err
+	}
+
+	var mu sync.Mutex
+	pages := map[int][]*hcloud.Server{}
+	err := listPages(ctx, "listing servers", concurrency, l, func(page int) (*hcloud.Response, error) {
+		pageOpts := opts
+		pageOpts.Page = page
+		pageOpts.PerPage = maxPerPage
+		servers, resp, err := lister.List(ctx, pageOpts)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		pages[page] = servers
+		mu.Unlock()
+		return resp, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var servers []*hcloud.Server
+	for page := 1; page <= len(pages); page++ {
+		servers = append(servers, pages[page]...)
+	}
+	return servers, nil
+}
+
+// pingServers lists a single server to check that the server API can be
+// reached with the API token. Server APIs which cannot list single pages list
+// all servers instead.
+func pingServers(ctx context.Context, serverAPI ServerAPI) error {
+	if lister, ok := serverAPI.(serverLister); ok {
+		_, _, err := lister.List(ctx, hcloud.ServerListOpts{ListOpts: hcloud.ListOpts{PerPage: 1}})
+		return err
+	}
+	_, err := serverAPI.AllWithOpts(ctx, hcloud.ServerListOpts{})
+	return err
+}
+
+// fillFloatingIPs replaces the floating IPs of the servers, of which the
+// server API only returns the IDs, with the full floating IPs. The floating
+// IPs are only listed if a server has one.
+func fillFloatingIPs(ctx context.Context, floatingIPAPI FloatingIPAPI, servers []*hcloud.Server, concurrency int, l *log.Logger) error {
+	missing := false
+	for _, s := range servers {
+		for _, ip := range s.PublicNet.FloatingIPs {
+			if ip.IP == nil {
+				missing = true
+			}
+		}
+	}
+	if !missing {
+		return nil
+	}
+
+	var mu sync.Mutex
+	floatingIPs := map[int]*hcloud.FloatingIP{}
+	err := listPages(ctx, "listing floating IPs", concurrency, l, func(page int) (*hcloud.Response, error) {
+		opts := hcloud.FloatingIPListOpts{ListOpts: hcloud.ListOpts{Page: page, PerPage: maxPerPage}}
+		ips, resp, err := floatingIPAPI.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		for _, ip := range ips {
+			floatingIPs[ip.ID] = ip
+		}
+		mu.Unlock()
+		return resp, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, s := range servers {
+		for i, ip := range s.PublicNet.FloatingIPs {
+			if full, ok := floatingIPs[ip.ID]; ok && ip.IP == nil {
+				s.PublicNet.FloatingIPs[i] = full
+			}
+		}
+	}
+	return nil
+}
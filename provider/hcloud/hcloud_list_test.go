@@ -0,0 +1,91 @@
+package hcloud
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+// pagedServerAPI serves servers in pages of maxPerPage and waits delay per
+// request like a remote API.
+type pagedServerAPI struct {
+	idServerAPI
+	servers []*hcloud.Server
+	delay   time.Duration
+	calls   int32
+}
+
+func (a *pagedServerAPI) List(ctx context.Context, opts hcloud.ServerListOpts) ([]*hcloud.Server, *hcloud.Response, error) {
+	atomic.AddInt32(&a.calls, 1)
+	time.Sleep(a.delay)
+	if opts.PerPage != maxPerPage {
+		return nil, nil, fmt.Errorf("got per page %d want %d", opts.PerPage, maxPerPage)
+	}
+
+	lastPage := (len(a.servers) + maxPerPage - 1) / maxPerPage
+	start := (opts.Page - 1) * maxPerPage
+	end := start + maxPerPage
+	if end > len(a.servers) {
+		end = len(a.servers)
+	}
+	resp := &hcloud.Response{Meta: hcloud.Meta{Pagination: &hcloud.Pagination{Page: opts.Page, LastPage: lastPage}}}
+	return a.servers[start:end], resp, nil
+}
+
+func newPagedServerAPI(n int, delay time.Duration) *pagedServerAPI {
+	a := &pagedServerAPI{delay: delay}
+	for i := 1; i <= n; i++ {
+		a.servers = append(a.servers, &hcloud.Server{ID: i})
+	}
+	return a
+}
+
+func TestListServers(t *testing.T) {
+	l := log.New(ioutil.Discard, "", 0)
+	for _, n := range []int{0, 1, 50, 51, 420} {
+		api := newPagedServerAPI(n, 0)
+		servers, err := listServers(context.Background(), api, hcloud.ServerListOpts{}, 4, l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(servers, api.servers) {
+			t.Fatalf("%d servers: got %d servers or wrong order", n, len(servers))
+		}
+		wantCalls := (n + maxPerPage - 1) / maxPerPage
+		if wantCalls == 0 {
+			wantCalls = 1
+		}
+		if got := int(atomic.LoadInt32(&api.calls)); got != wantCalls {
+			t.Fatalf("%d servers: got %d calls want %d", n, got, wantCalls)
+		}
+	}
+
+	// server APIs without List fall back to AllWithOpts
+	api := idServerAPI{1: {ID: 1}}
+	if _, err := listServers(context.Background(), api, hcloud.ServerListOpts{}, 4, l); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkListServers lists 1000 servers in 20 pages with an API latency
+// of 5ms per request.
+func BenchmarkListServers(b *testing.B) {
+	l := log.New(ioutil.Discard, "", 0)
+	for _, concurrency := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			api := newPagedServerAPI(1000, 5*time.Millisecond)
+			for i := 0; i < b.N; i++ {
+				if _, err := listServers(context.Background(), api, hcloud.ServerListOpts{}, concurrency, l); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
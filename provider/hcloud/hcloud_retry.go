@@ -0,0 +1,84 @@
+package hcloud
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+var (
+	// retryWaitMin is the wait time before the first retry.
+	retryWaitMin = 500 * time.Millisecond
+
+	// retryWaitMax is the maximum wait time between two retries.
+	retryWaitMax = 8 * time.Second
+)
+
+// retryableCodes are the API error codes of transient errors.
+var retryableCodes = map[hcloud.ErrorCode]bool{
+	hcloud.ErrorCodeRateLimitExceeded: true,
+	hcloud.ErrorCodeServiceError:      true,
+	hcloud.ErrorCodeUnknownError:      true,
+	hcloud.ErrorCodeMaintenance:       true,
+	hcloud.ErrorCodeRobotUnavailable:  true,
+}
+
+// retry calls fn until it succeeds, fails with an error which is not
+// retryable or ctx expires. The wait time between two attempts doubles
+// from retryWaitMin up to retryWaitMax.
+func retry(ctx context.Context, op string, l *log.Logger, fn func() error) error {
+	wait := retryWaitMin
+	for {
+		err := fn()
+		if err == nil || !retryable(err) {
+			return err
+		}
+
+		l.Printf("[INFO] discover-hcloud: %s failed, retrying in %s: %s", op, wait, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+		if wait > retryWaitMax {
+			wait = retryWaitMax
+		}
+	}
+}
+
+// lookupError is a failed API call of a lookup.
+type lookupError struct {
+	msg     string
+	cause   error
+	timeout bool
+}
+
+func (e *lookupError) Error() string { return e.msg }
+
+// Retryable reports whether a lookup which failed with err may succeed when
+// retried. This is the case for rate limit errors, server side errors and
+// network errors. Timeouts are fatal since the API calls have already been
+// retried until the timeout expired. Invalid configs and other API errors
+// are fatal, too.
+func (p *Provider) Retryable(err error) bool {
+	e, ok := err.(*lookupError)
+	if !ok || e.timeout {
+		return false
+	}
+	return retryable(e.cause)
+}
+
+// retryable returns true for rate limit errors, server side errors and
+// network errors.
+func retryable(err error) bool {
+	if apiErr, ok := err.(hcloud.Error); ok {
+		return retryableCodes[apiErr.Code]
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
@@ -0,0 +1,103 @@
+package hcloud
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{hcloud.Error{Code: hcloud.ErrorCodeRateLimitExceeded}, true},
+		{hcloud.Error{Code: hcloud.ErrorCodeServiceError}, true},
+		{hcloud.Error{Code: hcloud.ErrorCodeInvalidInput}, false},
+		{hcloud.Error{Code: hcloud.ErrorCodeForbidden}, false},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{&url.Error{Op: "Get", URL: "https://api.hetzner.cloud/v1/servers", Err: &net.DNSError{Err: "no such host", IsTimeout: true}}, true},
+		{errors.New("hcloud: server responded with status code 503"), false},
+		{errors.New("discover-hcloud: invalid timeout"), false},
+	}
+
+	for _, tt := range tests {
+		if got, want := retryable(tt.err), tt.retryable; got != want {
+			t.Fatalf("%v: got %v want %v", tt.err, got, want)
+		}
+	}
+}
+
+func TestProviderRetryable(t *testing.T) {
+	ctx := context.Background()
+	expired, cancel := context.WithTimeout(ctx, 0)
+	defer cancel()
+	<-expired.Done()
+
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{apiError(expired, time.Second, errors.New("context deadline exceeded")), false},
+		{apiError(expired, time.Second, hcloud.Error{Code: hcloud.ErrorCodeRateLimitExceeded}), false},
+		{apiError(ctx, time.Second, hcloud.Error{Code: hcloud.ErrorCodeRateLimitExceeded}), true},
+		{apiError(ctx, time.Second, hcloud.Error{Code: hcloud.ErrorCodeForbidden}), false},
+		{errors.New("discover-hcloud: invalid address type foo"), false},
+	}
+
+	p := &Provider{}
+	for _, tt := range tests {
+		if got, want := p.Retryable(tt.err), tt.retryable; got != want {
+			t.Fatalf("%v: got %v want %v", tt.err, got, want)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	defer func(min, max time.Duration) { retryWaitMin, retryWaitMax = min, max }(retryWaitMin, retryWaitMax)
+	retryWaitMin, retryWaitMax = time.Millisecond, 2*time.Millisecond
+
+	l := log.New(ioutil.Discard, "", 0)
+	rateLimited := hcloud.Error{Code: hcloud.ErrorCodeRateLimitExceeded}
+
+	t.Run("succeeds after transient errors", func(t *testing.T) {
+		calls := 0
+		err := retry(context.Background(), "test", l, func() error {
+			if calls++; calls < 3 {
+				return rateLimited
+			}
+			return nil
+		})
+		if err != nil || calls != 3 {
+			t.Fatalf("got err %v after %d calls want nil after 3", err, calls)
+		}
+	})
+
+	t.Run("fails immediately on fatal errors", func(t *testing.T) {
+		calls := 0
+		fatal := hcloud.Error{Code: hcloud.ErrorCodeInvalidInput}
+		err := retry(context.Background(), "test", l, func() error {
+			calls++
+			return fatal
+		})
+		if err != fatal || calls != 1 {
+			t.Fatalf("got err %v after %d calls want %v after 1", err, calls, fatal)
+		}
+	})
+
+	t.Run("stops when the context expires", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err := retry(ctx, "test", l, func() error { return rateLimited })
+		if err != rateLimited || ctx.Err() == nil {
+			t.Fatalf("got err %v want %v after context expired", err, rateLimited)
+		}
+	})
+}
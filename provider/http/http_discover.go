@@ -0,0 +1,312 @@
+// Package http provides node discovery with a generic HTTP endpoint.
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-discover/provider"
+)
+
+const (
+	// defaultTimeout is the default timeout of the request.
+	defaultTimeout = 10 * time.Second
+
+	// maxBodySize limits the size of the response which is read.
+	maxBodySize = 10 << 20
+)
+
+type Provider struct {
+	userAgent string
+}
+
+func (p *Provider) SetUserAgent(s string) {
+	p.userAgent = s
+}
+
+func (p *Provider) Help() string {
+	return `HTTP:
+
+    provider:     "http"
+    url:          The URL which returns the addresses.
+    addr_path:    The path of the addresses in a JSON response, e.g.
+                  "nodes.*.ip". Optional.
+    bearer_token: A token sent in an "Authorization: Bearer" header. Optional.
+    username:     The username for basic authentication. Optional.
+    password:     The password for basic authentication. Optional.
+    ca_cert:      The path of the CA certificate to verify the server with.
+    client_cert:  The path of the client certificate for mTLS.
+    client_key:   The path of the client key for mTLS.
+    timeout:      The timeout of the request (eg. "5s"). (default: "10s")
+
+    The URL must respond with status 200 and either one address per line,
+    where empty lines and lines starting with "#" are ignored, or a JSON
+    array of addresses:
+
+      ["10.0.0.1", "10.0.0.2"]
+
+    For other JSON documents "addr_path" selects the addresses. It is a
+    list of object keys and array indexes separated by dots, where "*"
+    matches all elements of an array or values of an object. A leading
+    "$." is ignored. For example "nodes.*.ip" selects both addresses of
+
+      {"nodes": [{"ip": "10.0.0.1"}, {"ip": "10.0.0.2"}]}
+
+    Variables can also be provided by environment variables:
+    export DISCOVER_HTTP_BEARER_TOKEN for bearer_token
+    export DISCOVER_HTTP_PASSWORD for password
+`
+}
+
+func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// config is the parsed configuration of a lookup.
+type config struct {
+	url         string
+	bearerToken string
+	username    string
+	password    string
+	timeout     time.Duration
+	client      *http.Client
+}
+
+// parseConfig parses and validates the arguments of a lookup and loads the
+// TLS certificates.
+func parseConfig(args map[string]string) (*config, error) {
+	if args["provider"] != "http" {
+		return nil, fmt.Errorf("discover-http: invalid provider " + args["provider"])
+	}
+
+	c := &config{
+		url:         args["url"],
+		bearerToken: provider.ArgsOrEnv(args, "bearer_token", "DISCOVER_HTTP_BEARER_TOKEN"),
+		username:    args["username"],
+		password:    provider.ArgsOrEnv(args, "password", "DISCOVER_HTTP_PASSWORD"),
+		timeout:     defaultTimeout,
+	}
+	if c.url == "" {
+		return nil, fmt.Errorf("discover-http: no url specified")
+	}
+	if u, err := url.Parse(c.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("discover-http: invalid url: must be an http or https URL")
+	}
+
+	if v := args["timeout"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("discover-http: invalid timeout %q", v)
+		}
+		c.timeout = d
+	}
+
+	client, err := provider.TLSClient(args["ca_cert"], args["client_cert"], args["client_key"])
+	if err != nil {
+		return nil, fmt.Errorf("discover-http: %s", err)
+	}
+	client.Timeout = c.timeout
+	c.client = client
+	return c, nil
+}
+
+// Retryable retries network errors and responses with status 429 or 5xx,
+// see provider.Retryable.
+func (p *Provider) Retryable(err error) bool {
+	return provider.Retryable(err)
+}
+
+// Ping checks that the url can be fetched with the credentials. The
+// response body is not parsed.
+func (p *Provider) Ping(ctx context.Context, args map[string]string, l *log.Logger) error {
+	c, err := parseConfig(args)
+	if err != nil {
+		return err
+	}
+	resp, err := p.get(ctx, c)
+	if err != nil {
+		return provider.PrefixError("discover-http", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// get fetches the url of the config with its credentials and returns the
+// response if its status is 200 OK. The caller must close the response body.
+func (p *Provider) get(ctx context.Context, c *config) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if c.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	if p.userAgent != "" {
+		req.Header.Set("User-Agent", p.userAgent)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, &provider.RequestError{Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &provider.RequestError{
+			StatusCode: resp.StatusCode,
+			Err:        fmt.Errorf("%s returned %s", safeURL(req.URL), resp.Status),
+		}
+	}
+	return resp, nil
+}
+
+// AddrsContext looks up the addresses like Addrs and aborts the request when
+// ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
+	c, err := parseConfig(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if l == nil {
+		l = log.New(ioutil.Discard, "", 0)
+	}
+
+	if u, err := url.Parse(c.url); err == nil {
+		l.Printf("[DEBUG] discover-http: Fetching %s", safeURL(u))
+	}
+	resp, err := p.get(ctx, c)
+	if err != nil {
+		return nil, provider.PrefixError("discover-http", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return nil, fmt.Errorf("discover-http: %s", err)
+	}
+
+	addrs, err := ParseAddrs(body, args["addr_path"])
+	if err != nil {
+		return nil, fmt.Errorf("discover-http: %s", err)
+	}
+	l.Printf("[DEBUG] discover-http: Found %d addresses", len(addrs))
+	return addrs, nil
+}
+
+// ParseAddrs returns the addresses of a response. A JSON response must be an
+// array of addresses unless path selects them. Other responses contain one
+// address per line.
+func ParseAddrs(body []byte, path string) ([]string, error) {
+	trimmed := bytes.TrimSpace(body)
+	isJSON := len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{')
+	if !isJSON {
+		if path != "" {
+			return nil, fmt.Errorf("addr_path %q requires a JSON response", path)
+		}
+		return parseLines(trimmed)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(trimmed, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %s", err)
+	}
+
+	values := []interface{}{doc}
+	if path != "" {
+		var err error
+		values, err = selectPath(doc, path)
+		if err != nil {
+			return nil, err
+		}
+	} else if arr, ok := doc.([]interface{}); ok {
+		values = arr
+	} else {
+		return nil, fmt.Errorf("JSON response is not an array, use addr_path to select the addresses")
+	}
+
+	var addrs []string
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("address %v is not a string", v)
+		}
+		if s != "" {
+			addrs = append(addrs, s)
+		}
+	}
+	return addrs, nil
+}
+
+func parseLines(body []byte) ([]string, error) {
+	var addrs []string
+	s := bufio.NewScanner(bytes.NewReader(body))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	return addrs, s.Err()
+}
+
+// selectPath returns the values at the dot separated path in doc.
+func selectPath(doc interface{}, path string) ([]interface{}, error) {
+	values := []interface{}{doc}
+	for _, key := range strings.Split(strings.TrimPrefix(path, "$."), ".") {
+		var next []interface{}
+		for _, v := range values {
+			switch v := v.(type) {
+			case map[string]interface{}:
+				if key == "*" {
+					for _, k := range sortedKeys(v) {
+						next = append(next, v[k])
+					}
+				} else if child, ok := v[key]; ok {
+					next = append(next, child)
+				}
+			case []interface{}:
+				if key == "*" {
+					next = append(next, v...)
+				} else if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(v) {
+					next = append(next, v[i])
+				}
+			}
+		}
+		values = next
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("addr_path %q does not match", path)
+	}
+	return values, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// safeURL returns the URL without credentials and query for logs and
+// errors.
+func safeURL(u *url.URL) string {
+	return u.Scheme + "://" + u.Host + u.Path
+}
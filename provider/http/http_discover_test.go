@@ -0,0 +1,196 @@
+package http_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	discover "github.com/hashicorp/go-discover"
+	discoverhttp "github.com/hashicorp/go-discover/provider/http"
+)
+
+var _ discover.Provider = (*discoverhttp.Provider)(nil)
+var _ discover.ProviderWithUserAgent = (*discoverhttp.Provider)(nil)
+var _ discover.ProviderWithPing = (*discoverhttp.Provider)(nil)
+var _ discover.ProviderWithRetryable = (*discoverhttp.Provider)(nil)
+
+func TestParseAddrs(t *testing.T) {
+	tests := []struct {
+		body  string
+		path  string
+		addrs []string
+		err   bool
+	}{
+		{"10.0.0.1\n\n# comment\n  10.0.0.2  \n", "", []string{"10.0.0.1", "10.0.0.2"}, false},
+		{"", "", nil, false},
+		{`["10.0.0.1", "10.0.0.2", ""]`, "", []string{"10.0.0.1", "10.0.0.2"}, false},
+		{`{"nodes": [{"ip": "10.0.0.1"}, {"ip": "10.0.0.2"}, {"name": "no-ip"}]}`, "nodes.*.ip", []string{"10.0.0.1", "10.0.0.2"}, false},
+		{`{"nodes": [{"ip": "10.0.0.1"}, {"ip": "10.0.0.2"}]}`, "$.nodes.1.ip", []string{"10.0.0.2"}, false},
+		{`{"zones": {"b": ["10.0.0.2"], "a": ["10.0.0.1"]}}`, "zones.*.*", []string{"10.0.0.1", "10.0.0.2"}, false},
+		{`{"nodes": []}`, "", nil, true},
+		{`{"nodes": []}`, "servers.*", nil, true},
+		{`[1, 2]`, "", nil, true},
+		{`[invalid`, "", nil, true},
+		{"10.0.0.1", "nodes.*.ip", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			addrs, err := discoverhttp.ParseAddrs([]byte(tt.body), tt.path)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(addrs, tt.addrs) {
+				t.Fatalf("got %v want %v", addrs, tt.addrs)
+			}
+		})
+	}
+}
+
+func TestAddrs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		switch {
+		case r.Header.Get("Authorization") == "Bearer secret":
+		case ok && user == "consul" && pass == "secret":
+		default:
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/nodes" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"nodes": [{"ip": "10.0.0.1"}, {"ip": "10.0.0.2"}]}`)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name string
+		args discover.Config
+		err  bool
+	}{
+		{"bearer token", discover.Config{"bearer_token": "secret"}, false},
+		{"basic auth", discover.Config{"username": "consul", "password": "secret"}, false},
+		{"unauthorized", discover.Config{"bearer_token": "wrong"}, true},
+		{"not found", discover.Config{"bearer_token": "secret", "url": ts.URL + "/missing"}, true},
+		{"invalid timeout", discover.Config{"bearer_token": "secret", "timeout": "soon"}, true},
+	}
+
+	p := &discoverhttp.Provider{}
+	l := log.New(os.Stderr, "", log.LstdFlags)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := discover.Config{"provider": "http", "url": ts.URL + "/nodes", "addr_path": "nodes.*.ip"}
+			for k, v := range tt.args {
+				args[k] = v
+			}
+			addrs, err := p.Addrs(args, l)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"10.0.0.1", "10.0.0.2"}; !reflect.DeepEqual(addrs, want) {
+				t.Fatalf("got %v want %v", addrs, want)
+			}
+		})
+	}
+}
+
+func TestPing(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "not parsed by ping")
+	}))
+	defer ts.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	p := &discoverhttp.Provider{}
+	l := log.New(os.Stderr, "", log.LstdFlags)
+	valid := func() map[string]string {
+		return map[string]string{"provider": "http", "url": ts.URL + "/nodes", "bearer_token": "secret"}
+	}
+	if err := p.Ping(context.Background(), valid(), l); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []map[string]string{
+		{"url": ""},
+		{"url": "example.com/nodes"},
+		{"timeout": "soon"},
+		{"ca_cert": "/nonexistent/ca.pem"},
+		{"bearer_token": "wrong"},
+		{"url": closed.URL},
+	}
+	for _, tt := range tests {
+		args := valid()
+		for k, v := range tt {
+			args[k] = v
+		}
+		if err := p.Ping(context.Background(), args, l); err == nil {
+			t.Fatalf("%v: expected error", tt)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	var status int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(status), status)
+	}))
+	defer ts.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusForbidden, false},
+		{http.StatusNotFound, false},
+	}
+	p := &discoverhttp.Provider{}
+	args := map[string]string{"provider": "http", "url": ts.URL + "/nodes"}
+	for _, tt := range tests {
+		status = tt.status
+		_, err := p.Addrs(args, nil)
+		if err == nil {
+			t.Fatalf("%d: expected error", tt.status)
+		}
+		if got := p.Retryable(err); got != tt.retryable {
+			t.Fatalf("%d: got retryable %v want %v: %s", tt.status, got, tt.retryable, err)
+		}
+	}
+
+	args["timeout"] = "soon"
+	if _, err := p.Addrs(args, nil); err == nil || p.Retryable(err) {
+		t.Fatalf("got error %v want fatal error", err)
+	}
+	delete(args, "timeout")
+	args["url"] = closed.URL
+	if _, err := p.Addrs(args, nil); err == nil || !p.Retryable(err) {
+		t.Fatalf("got error %v want retryable error", err)
+	}
+}
@@ -1,16 +1,18 @@
-// Package k8s provides pod discovery for Kubernetes.
+// Package k8s provides pod and endpoint discovery for Kubernetes.
 package k8s
 
 import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"path/filepath"
 	"strconv"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/mitchellh/go-homedir"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -38,6 +40,12 @@ func (p *Provider) Help() string {
     label_selector:   Label selector value to filter pods.
     field_selector:   Field selector value to filter pods.
     host_network:     "true" if pod host IP and ports should be used.
+    service_name:     Name of a service whose endpoint slices are used
+                      instead of listing the pods.
+    service_port:     Name of the endpoint slice port to append to the
+                      addresses of the service endpoints.
+    ready_only:       "false" to also return pods and endpoints which are
+                      not ready (defaults to "true").
 
     The kubeconfig file value will be searched in the following locations:
 
@@ -55,10 +63,22 @@ func (p *Provider) Help() string {
     a HostIP available will be selected. If a port annotation exists, then
     the port must be exposed via a HostPort as well, otherwise the pod will
     be ignored.
+
+    If "service_name" is set, the addresses of the endpoints of that
+    service are read from its EndpointSlices, which is much cheaper than
+    listing all pods on large clusters. The label and field selectors
+    then filter the endpoint slices and "host_network" and the port
+    annotation are not used. This requires Kubernetes 1.17 or later.
 `
 }
 
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and aborts listing the pods
+// when ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
 	if args["provider"] != "k8s" {
 		return nil, fmt.Errorf("discover-k8s: invalid provider " + args["provider"])
 	}
@@ -105,9 +125,28 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 		namespace = "default"
 	}
 
+	if service := args["service_name"]; service != "" {
+		// The endpoint slices of a service are labeled with its name.
+		selector := discoveryv1beta1.LabelServiceName + "=" + service
+		if v := args["label_selector"]; v != "" {
+			selector += "," + v
+		}
+		slices, err := clientset.DiscoveryV1beta1().EndpointSlices(namespace).List(
+			ctx,
+			metav1.ListOptions{
+				LabelSelector: selector,
+				FieldSelector: args["field_selector"],
+			})
+		if err != nil {
+			return nil, fmt.Errorf("discover-k8s: error listing endpoint slices: %s", err)
+		}
+
+		return EndpointSliceAddrs(slices, args, l)
+	}
+
 	// List all the pods based on the filters we requested
 	pods, err := clientset.CoreV1().Pods(namespace).List(
-		context.Background(),
+		ctx,
 		metav1.ListOptions{
 			LabelSelector: args["label_selector"],
 			FieldSelector: args["field_selector"],
@@ -134,6 +173,11 @@ func PodAddrs(pods *corev1.PodList, args map[string]string, l *log.Logger) ([]st
 		}
 	}
 
+	readyOnly, err := readyOnlyArg(args)
+	if err != nil {
+		return nil, err
+	}
+
 	var addrs []string
 PodLoop:
 	for _, pod := range pods.Items {
@@ -146,7 +190,7 @@ PodLoop:
 		// If there is a Ready condition available, we need that to be true.
 		// If no ready condition is set, then we accept this pod regardless.
 		for _, condition := range pod.Status.Conditions {
-			if condition.Type == corev1.PodReady && condition.Status != corev1.ConditionTrue {
+			if readyOnly && condition.Type == corev1.PodReady && condition.Status != corev1.ConditionTrue {
 				l.Printf("[DEBUG] discover-k8s: ignoring pod %q, not ready state", pod.Name)
 				continue PodLoop
 			}
@@ -173,7 +217,7 @@ PodLoop:
 				continue
 			}
 
-			addr = fmt.Sprintf("%s:%d", addr, port)
+			addr = net.JoinHostPort(addr, strconv.Itoa(int(port)))
 		}
 
 		addrs = append(addrs, addr)
@@ -182,6 +226,70 @@ PodLoop:
 	return addrs, nil
 }
 
+// EndpointSliceAddrs extracts the addresses of the endpoints from a list of
+// endpoint slices.
+//
+// This is a separate method so that we can unit test this without having
+// to setup complicated K8S cluster scenarios. It shouldn't generally be
+// called externally.
+func EndpointSliceAddrs(slices *discoveryv1beta1.EndpointSliceList, args map[string]string, l *log.Logger) ([]string, error) {
+	readyOnly, err := readyOnlyArg(args)
+	if err != nil {
+		return nil, err
+	}
+	portName := args["service_port"]
+
+	var addrs []string
+	for _, slice := range slices.Items {
+		var port string
+		if portName != "" {
+			for _, p := range slice.Ports {
+				if p.Name != nil && *p.Name == portName && p.Port != nil {
+					port = strconv.Itoa(int(*p.Port))
+				}
+			}
+			if port == "" {
+				l.Printf("[DEBUG] discover-k8s: ignoring endpoint slice %q, no port %q", slice.Name, portName)
+				continue
+			}
+		}
+
+		for _, endpoint := range slice.Endpoints {
+			// The endpoint is ready unless the condition says otherwise.
+			if readyOnly && endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				l.Printf("[DEBUG] discover-k8s: ignoring endpoint %v in slice %q, not ready", endpoint.Addresses, slice.Name)
+				continue
+			}
+
+			// All addresses of an endpoint are fungible, so we only use
+			// the first one.
+			if len(endpoint.Addresses) == 0 {
+				continue
+			}
+			addr := endpoint.Addresses[0]
+			if port != "" {
+				addr = net.JoinHostPort(addr, port)
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs, nil
+}
+
+// readyOnlyArg returns whether only ready pods and endpoints are returned.
+func readyOnlyArg(args map[string]string) (bool, error) {
+	v := args["ready_only"]
+	if v == "" {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("discover-k8s: ready_only must be boolean value: %s", err)
+	}
+	return b, nil
+}
+
 // podPort extracts the proper port for the address from the given pod
 // for a non-empty annotation.
 //
@@ -10,6 +10,7 @@ import (
 	discover "github.com/hashicorp/go-discover"
 	"github.com/hashicorp/go-discover/provider/k8s"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1beta1 "k8s.io/api/discovery/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -159,6 +160,26 @@ func TestPodAddrs(t *testing.T) {
 			[]string{"ready", "scheduled"},
 		},
 
+		{
+			"Not ready pods with ready_only=false",
+			map[string]string{"ready_only": "false"},
+			[]corev1.Pod{
+				corev1.Pod{
+					Status: corev1.PodStatus{
+						Phase: corev1.PodRunning,
+						PodIP: "not-ready",
+						Conditions: []corev1.PodCondition{
+							corev1.PodCondition{
+								Type:   corev1.PodReady,
+								Status: corev1.ConditionFalse,
+							},
+						},
+					},
+				},
+			},
+			[]string{"not-ready"},
+		},
+
 		{
 			"Port annotation (named)",
 			nil,
@@ -268,6 +289,26 @@ func TestPodAddrs(t *testing.T) {
 			[]string{"1.2.3.4:4600"},
 		},
 
+		{
+			"Port annotation (IPv6)",
+			nil,
+			[]corev1.Pod{
+				corev1.Pod{
+					Status: corev1.PodStatus{
+						Phase: corev1.PodRunning,
+						PodIP: "fd00::1",
+					},
+
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: map[string]string{
+							k8s.AnnotationKeyPort: "4600",
+						},
+					},
+				},
+			},
+			[]string{"[fd00::1]:4600"},
+		},
+
 		{
 			"Port annotation (direct with host network)",
 			map[string]string{"host_network": "true"},
@@ -318,3 +359,74 @@ func TestPodAddrs(t *testing.T) {
 		})
 	}
 }
+
+func TestEndpointSliceAddrs(t *testing.T) {
+	ready, notReady := true, false
+	serf, http := "serf", "http"
+	port := func(name string, port int32) discoveryv1beta1.EndpointPort {
+		return discoveryv1beta1.EndpointPort{Name: &name, Port: &port}
+	}
+
+	slices := &discoveryv1beta1.EndpointSliceList{
+		Items: []discoveryv1beta1.EndpointSlice{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "consul-abc"},
+				Endpoints: []discoveryv1beta1.Endpoint{
+					{Addresses: []string{"1.2.3.4", "1.2.3.5"}, Conditions: discoveryv1beta1.EndpointConditions{Ready: &ready}},
+					{Addresses: []string{"2.3.4.5"}, Conditions: discoveryv1beta1.EndpointConditions{Ready: &notReady}},
+					{Addresses: []string{"fd00::1"}},
+				},
+				Ports: []discoveryv1beta1.EndpointPort{port(serf, 8301), port(http, 8500)},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "consul-def"},
+				Endpoints: []discoveryv1beta1.Endpoint{
+					{Addresses: []string{"3.4.5.6"}},
+				},
+				Ports: []discoveryv1beta1.EndpointPort{port(http, 8500)},
+			},
+		},
+	}
+
+	cases := []struct {
+		Name     string
+		Args     map[string]string
+		Expected []string
+	}{
+		{
+			"Ready endpoints",
+			nil,
+			[]string{"1.2.3.4", "fd00::1", "3.4.5.6"},
+		},
+
+		{
+			"All endpoints with ready_only=false",
+			map[string]string{"ready_only": "false"},
+			[]string{"1.2.3.4", "2.3.4.5", "fd00::1", "3.4.5.6"},
+		},
+
+		{
+			"Named port",
+			map[string]string{"service_port": "serf"},
+			[]string{"1.2.3.4:8301", "[fd00::1]:8301"},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			l := log.New(os.Stderr, "", log.LstdFlags)
+			addrs, err := k8s.EndpointSliceAddrs(slices, tt.Args, l)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+
+			if !reflect.DeepEqual(addrs, tt.Expected) {
+				t.Fatalf("bad: %#v", addrs)
+			}
+		})
+	}
+
+	if _, err := k8s.EndpointSliceAddrs(slices, map[string]string{"ready_only": "maybe"}, nil); err == nil {
+		t.Fatal("expected error for invalid ready_only")
+	}
+}
@@ -41,6 +41,12 @@ func (p *Provider) Help() string {
 }
 
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and aborts the API calls
+// when ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
 	if args["provider"] != "linode" {
 		return nil, fmt.Errorf("discover-linode: invalid provider " + args["provider"])
 	}
@@ -72,14 +78,14 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 	jsonFilters, _ := json.Marshal(filters)
 	filterOpt := linodego.ListOptions{Filter: string(jsonFilters)}
 
-	linodes, err := client.ListInstances(context.Background(), &filterOpt)
+	linodes, err := client.ListInstances(ctx, &filterOpt)
 	if err != nil {
 		return nil, fmt.Errorf("discover-linode: Fetching Linode instances failed: %s", err)
 	}
 
 	var addrs []string
 	for _, linode := range linodes {
-		addr, err := client.GetInstanceIPAddresses(context.Background(), linode.ID)
+		addr, err := client.GetInstanceIPAddresses(ctx, linode.ID)
 		if err != nil {
 			return nil, fmt.Errorf("discover-linode: Fetching Linode IP address for instance %v failed: %s", linode.ID, err)
 		}
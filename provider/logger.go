@@ -0,0 +1,12 @@
+package provider
+
+// Logger is a leveled, structured logger. It is the subset of the
+// hclog.Logger interface used by go-discover so that an hclog.Logger can be
+// used directly. The args are alternating keys and values.
+type Logger interface {
+	Trace(msg string, args ...interface{})
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
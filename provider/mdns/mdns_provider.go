@@ -2,6 +2,7 @@
 package mdns
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -9,6 +10,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/hashicorp/go-discover/provider"
 	m "github.com/hashicorp/mdns"
 )
 
@@ -22,6 +24,8 @@ func (p *Provider) Help() string {
     provider:          "mdns"
     service:           The mDNS service name.
     domain:            The mDNS discovery domain.  Default "local".
+    interface:         The name of the network interface to query on, e.g.
+                       "eth0".  Default all multicast interfaces.
     timeout:           The mDNS lookup timeout.  Default "5s" (five seconds).
     v6:                IPv6 will be allowed and preferred when set to "true"
                        and disabled when set to "false".  Default "true".
@@ -32,6 +36,13 @@ func (p *Provider) Help() string {
 
 // Addrs returns discovered addresses for the mDNS package.
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext returns discovered addresses like Addrs and returns when ctx
+// is done. The query is limited to the deadline of ctx but mdns.Query
+// cannot be canceled, so a canceled query keeps listening until its timeout.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
 	var params *m.QueryParam
 	var ch chan *m.ServiceEntry
 	var v6, v4 bool
@@ -68,6 +79,11 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 	} else {
 		params.Timeout = 5 * time.Second
 	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if d := time.Until(deadline); d < params.Timeout {
+			params.Timeout = d
+		}
+	}
 
 	// validate and set v6 toggle
 	if args["v6"] != "" {
@@ -87,13 +103,21 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 		v4 = true
 	}
 
+	// validate and set interface
+	if args["interface"] != "" {
+		if params.Interface, err = net.InterfaceByName(args["interface"]); err != nil {
+			return nil, fmt.Errorf("discover-mdns: Failed to find interface: %s", err)
+		}
+	}
+
 	// init entries channel
 	ch = make(chan *m.ServiceEntry)
-	defer close(ch)
 	params.Entries = ch
 
 	// build addresses
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		var addr string
 		for e := range ch {
 			addr = "" // reset addr each loop
@@ -114,6 +138,11 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 		}
 	}()
 
-	// lookup and return
-	return addrs, m.Query(params)
+	// lookup and wait until all entries are processed before returning
+	return provider.LookupContext(ctx, func() ([]string, error) {
+		err := m.Query(params)
+		close(ch)
+		<-done
+		return addrs, err
+	})
 }
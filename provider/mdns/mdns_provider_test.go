@@ -1,10 +1,12 @@
 package mdns_test
 
 import (
+	"context"
 	"log"
 	"net"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/mdns"
 
@@ -12,6 +14,8 @@ import (
 	provider "github.com/hashicorp/go-discover/provider/mdns"
 )
 
+var _ discover.ProviderWithContext = (*provider.Provider)(nil)
+
 func newTestServer() (*mdns.Server, error) {
 	zone, err := mdns.NewMDNSService(
 		"localhost",
@@ -102,6 +106,18 @@ func TestDiscover(t *testing.T) {
 			true,
 			0,
 		},
+		{
+			"invalid config - unknown interface",
+			discover.Config{
+				"provider":  "mdns",
+				"service":   "_fake-service._noop",
+				"domain":    "test",
+				"timeout":   "1s",
+				"interface": "no-such-interface0",
+			},
+			true,
+			0,
+		},
 		{
 			"invalid config - bad v4 option",
 			discover.Config{
@@ -143,3 +159,23 @@ func TestDiscover(t *testing.T) {
 		t.Logf("PASS [%d/%d] %s", idx, len(cases), tc.desc)
 	}
 }
+
+func TestAddrsContextCanceled(t *testing.T) {
+	p := &provider.Provider{}
+	args := discover.Config{
+		"provider": "mdns",
+		"service":  "_fake-service._noop",
+		"domain":   "local",
+		"timeout":  "10s",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := p.AddrsContext(ctx, args, nil); err != context.Canceled {
+		t.Fatalf("got error %v want %v", err, context.Canceled)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("took %s after cancel", d)
+	}
+}
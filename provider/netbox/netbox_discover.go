@@ -0,0 +1,366 @@
+// Package netbox provides node discovery for Netbox.
+package netbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-discover/provider"
+)
+
+const (
+	// defaultTimeout is the default timeout of a lookup.
+	defaultTimeout = 30 * time.Second
+
+	// pageSize is the number of objects requested per page.
+	pageSize = 250
+)
+
+// endpoints are the API endpoints of the object kinds.
+var endpoints = map[string]string{
+	"devices":          "/api/dcim/devices/",
+	"virtual_machines": "/api/virtualization/virtual-machines/",
+}
+
+// page is a page of a Netbox object list.
+type page struct {
+	Next    string   `json:"next"`
+	Results []object `json:"results"`
+}
+
+// object is a device or virtual machine.
+type object struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Site   *ref   `json:"site"`
+	Status *struct {
+		Value string `json:"value"`
+	} `json:"status"`
+	PrimaryIP4 *ipAddress `json:"primary_ip4"`
+	PrimaryIP6 *ipAddress `json:"primary_ip6"`
+}
+
+type ref struct {
+	Slug string `json:"slug"`
+}
+
+type ipAddress struct {
+	Address string `json:"address"`
+}
+
+type Provider struct {
+	userAgent string
+}
+
+func (p *Provider) SetUserAgent(s string) {
+	p.userAgent = s
+}
+
+func (p *Provider) Help() string {
+	return `Netbox:
+
+    provider:     "netbox"
+    url:          The URL of the Netbox instance, e.g. "https://netbox.example.com".
+    token:        The Netbox API token.
+    kind:         "devices", "virtual_machines" or "all". (default: "all")
+    tag:          Only return the objects with this tag slug. Optional.
+    site:         Only return the objects at the site with this slug. Optional.
+    status:       Only return the objects with this status. (default: "active")
+    custom_field: Only return the objects with this custom field value,
+                  e.g. "consul_role=server". Optional.
+    address_type: "ipv4" or "ipv6" for the primary IPv4 or IPv6 address.
+                  (default: "ipv4")
+    timeout:      The timeout of the lookup (eg. "10s"). (default: "30s")
+    ca_cert:      The path of the CA certificate to verify the API with.
+    client_cert:  The path of the client certificate for mTLS.
+    client_key:   The path of the client key for mTLS.
+
+    Objects without a primary address of the address type are skipped.
+    Further pages are always fetched from the url, only the path and query
+    of the next page returned by Netbox are used. This keeps the token on
+    the configured host if Netbox runs behind a reverse proxy.
+
+    Variables can also be provided by environment variables:
+    export NETBOX_URL for url
+    export NETBOX_TOKEN for token
+    export NETBOX_CACERT for ca_cert
+    export NETBOX_CLIENT_CERT for client_cert
+    export NETBOX_CLIENT_KEY for client_key
+`
+}
+
+func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and aborts the API requests
+// when ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
+	nodes, err := p.Nodes(ctx, args, l)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Addrs(nodes), nil
+}
+
+// config is the parsed configuration of a lookup.
+type config struct {
+	url         *url.URL
+	token       string
+	kinds       []string
+	addressType string
+	query       url.Values
+	timeout     time.Duration
+	client      *http.Client
+}
+
+// parseConfig parses and validates the arguments of a lookup.
+func parseConfig(args map[string]string) (*config, error) {
+	if args["provider"] != "netbox" {
+		return nil, fmt.Errorf("discover-netbox: invalid provider " + args["provider"])
+	}
+
+	c := &config{
+		token:       provider.ArgsOrEnv(args, "token", "NETBOX_TOKEN"),
+		addressType: args["address_type"],
+		timeout:     defaultTimeout,
+	}
+	rawURL := provider.ArgsOrEnv(args, "url", "NETBOX_URL")
+	if rawURL == "" {
+		return nil, fmt.Errorf("discover-netbox: no url specified")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("discover-netbox: invalid url %q", rawURL)
+	}
+	c.url = u
+
+	switch kind := args["kind"]; kind {
+	case "", "all":
+		c.kinds = []string{"devices", "virtual_machines"}
+	case "devices", "virtual_machines":
+		c.kinds = []string{kind}
+	default:
+		return nil, fmt.Errorf("discover-netbox: invalid kind %q", kind)
+	}
+
+	switch c.addressType {
+	case "":
+		c.addressType = "ipv4"
+	case "ipv4", "ipv6":
+	default:
+		return nil, fmt.Errorf("discover-netbox: invalid address_type %q", c.addressType)
+	}
+
+	status := args["status"]
+	if status == "" {
+		status = "active"
+	}
+
+	c.query = url.Values{
+		"status": {status},
+		"limit":  {strconv.Itoa(pageSize)},
+	}
+	if v := args["tag"]; v != "" {
+		c.query.Set("tag", v)
+	}
+	if v := args["site"]; v != "" {
+		c.query.Set("site", v)
+	}
+	if v := args["custom_field"]; v != "" {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("discover-netbox: invalid custom_field %q", v)
+		}
+		c.query.Set("cf_"+kv[0], kv[1])
+	}
+
+	if v := args["timeout"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("discover-netbox: invalid timeout %q", v)
+		}
+		c.timeout = d
+	}
+
+	client, err := provider.TLSClient(
+		provider.ArgsOrEnv(args, "ca_cert", "NETBOX_CACERT"),
+		provider.ArgsOrEnv(args, "client_cert", "NETBOX_CLIENT_CERT"),
+		provider.ArgsOrEnv(args, "client_key", "NETBOX_CLIENT_KEY"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("discover-netbox: %s", err)
+	}
+	c.client = client
+	return c, nil
+}
+
+// Retryable retries network errors and responses with status 429 or 5xx,
+// see provider.Retryable.
+func (p *Provider) Retryable(err error) bool {
+	return provider.Retryable(err)
+}
+
+// Ping checks that the objects of the first kind can be read with the token
+// by fetching a page with a single object.
+func (p *Provider) Ping(ctx context.Context, args map[string]string, l *log.Logger) error {
+	c, err := parseConfig(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	q := url.Values{"limit": {"1"}}
+	u := strings.TrimSuffix(c.url.String(), "/") + endpoints[c.kinds[0]] + "?" + q.Encode()
+	var pg page
+	if err := p.get(ctx, c.client, u, c.token, &pg); err != nil {
+		return provider.PrefixError("discover-netbox", err)
+	}
+	return nil
+}
+
+// Nodes returns the primary addresses of the matching devices and virtual
+// machines together with their name, ID, site and status.
+func (p *Provider) Nodes(ctx context.Context, args map[string]string, l *log.Logger) ([]provider.Node, error) {
+	c, err := parseConfig(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if l == nil {
+		l = log.New(ioutil.Discard, "", 0)
+	}
+
+	kinds, addressType, q := c.kinds, c.addressType, c.query
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	l.Printf("[DEBUG] discover-netbox: Using kind=%v filter=%s address_type=%s", kinds, q.Encode(), addressType)
+
+	var nodes []provider.Node
+	for _, kind := range kinds {
+		next := strings.TrimSuffix(c.url.String(), "/") + endpoints[kind] + "?" + q.Encode()
+		for next != "" {
+			var pg page
+			if err := p.get(ctx, c.client, next, c.token, &pg); err != nil {
+				return nil, provider.PrefixError("discover-netbox", err)
+			}
+			for _, o := range pg.Results {
+				addr := o.primaryAddr(addressType)
+				if addr == "" {
+					l.Printf("[DEBUG] discover-netbox: %s %s (%d) has no primary %s address", kind, o.Name, o.ID, addressType)
+					continue
+				}
+				nodes = append(nodes, o.node(kind, addr))
+			}
+			u, err := c.pageURL(pg.Next)
+			if err != nil {
+				return nil, err
+			}
+			next = u
+		}
+	}
+
+	l.Printf("[DEBUG] discover-netbox: Found %d addresses", len(nodes))
+	return nodes, nil
+}
+
+// pageURL returns the URL of the next page of a list or an empty string on
+// the last page. Netbox builds the next
+// page from the host it sees, which differs from the url behind a reverse
+// proxy, so only its path and query are used. The path is prefixed with the
+// path of the url unless Netbox already includes it.
+func (c *config) pageURL(next string) (string, error) {
+	if next == "" {
+		return "", nil
+	}
+	n, err := url.Parse(next)
+	if err != nil {
+		return "", fmt.Errorf("discover-netbox: invalid next page %q", next)
+	}
+	u := *c.url
+	base := strings.TrimSuffix(c.url.Path, "/")
+	u.Path = n.Path
+	if !strings.HasPrefix(n.Path, base+"/") {
+		u.Path = base + n.Path
+	}
+	u.RawPath = ""
+	u.RawQuery = n.RawQuery
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// get decodes the API response for u into v.
+func (p *Provider) get(ctx context.Context, client *http.Client, u, token string, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+	if p.userAgent != "" {
+		req.Header.Set("User-Agent", p.userAgent)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return &provider.RequestError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &provider.RequestError{StatusCode: resp.StatusCode, Err: fmt.Errorf("GET %s: %s", req.URL.Path, resp.Status)}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("GET %s: invalid response: %s", req.URL.Path, err)
+	}
+	return nil
+}
+
+// primaryAddr returns the primary address of the address type without the
+// prefix length.
+func (o object) primaryAddr(addressType string) string {
+	ip := o.PrimaryIP4
+	if addressType == "ipv6" {
+		ip = o.PrimaryIP6
+	}
+	if ip == nil {
+		return ""
+	}
+	addr, _, err := net.ParseCIDR(ip.Address)
+	if err != nil {
+		return ip.Address
+	}
+	return addr.String()
+}
+
+func (o object) node(kind, addr string) provider.Node {
+	n := provider.Node{
+		Addr: addr,
+		Name: o.Name,
+		ID:   strconv.Itoa(o.ID),
+		Meta: map[string]string{"kind": kind},
+	}
+	if o.Site != nil {
+		n.Zone = o.Site.Slug
+	}
+	if o.Status != nil {
+		n.Meta["status"] = o.Status.Value
+	}
+	return n
+}
@@ -0,0 +1,260 @@
+package netbox_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	discover "github.com/hashicorp/go-discover"
+	"github.com/hashicorp/go-discover/provider/netbox"
+)
+
+var _ discover.Provider = (*netbox.Provider)(nil)
+var _ discover.ProviderWithUserAgent = (*netbox.Provider)(nil)
+var _ discover.NodeProvider = (*netbox.Provider)(nil)
+var _ discover.ProviderWithPing = (*netbox.Provider)(nil)
+var _ discover.ProviderWithRetryable = (*netbox.Provider)(nil)
+
+func testServer(t *testing.T) *httptest.Server {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Token secret"; got != want {
+			http.Error(w, `{"detail": "Invalid token"}`, http.StatusForbidden)
+			return
+		}
+		q := r.URL.Query()
+		if got, want := q.Get("status"), "active"; got != want {
+			t.Errorf("got status %q want %q", got, want)
+		}
+
+		switch {
+		case r.URL.Path == "/api/dcim/devices/" && q.Get("offset") == "":
+			// no device has the custom field
+			if q.Get("cf_consul_role") != "" {
+				fmt.Fprint(w, `{"next": null, "results": []}`)
+				return
+			}
+			fmt.Fprintf(w, `{"next": "%s/api/dcim/devices/?offset=1&status=active", "results": [
+				{"id": 1, "name": "server-1", "site": {"slug": "fra1"}, "status": {"value": "active"}, "primary_ip4": {"address": "10.0.0.1/24"}, "primary_ip6": {"address": "fd00::1/64"}}
+			]}`, ts.URL)
+		case r.URL.Path == "/api/dcim/devices/":
+			fmt.Fprint(w, `{"next": null, "results": [
+				{"id": 2, "name": "server-2", "site": {"slug": "fra1"}, "status": {"value": "active"}, "primary_ip4": {"address": "10.0.0.2/24"}, "primary_ip6": null},
+				{"id": 3, "name": "switch-1", "site": {"slug": "fra1"}, "status": {"value": "active"}, "primary_ip4": null, "primary_ip6": null}
+			]}`)
+		case r.URL.Path == "/api/virtualization/virtual-machines/":
+			fmt.Fprint(w, `{"next": null, "results": [
+				{"id": 7, "name": "vm-1", "site": null, "status": {"value": "active"}, "primary_ip4": {"address": "10.0.1.1/24"}, "primary_ip6": {"address": "fd00::7/64"}}
+			]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return ts
+}
+
+func TestAddrs(t *testing.T) {
+	ts := testServer(t)
+	defer ts.Close()
+
+	tests := []struct {
+		name  string
+		args  discover.Config
+		addrs []string
+		err   bool
+	}{
+		{"all", discover.Config{}, []string{"10.0.0.1", "10.0.0.2", "10.0.1.1"}, false},
+		{"devices", discover.Config{"kind": "devices"}, []string{"10.0.0.1", "10.0.0.2"}, false},
+		{"ipv6", discover.Config{"address_type": "ipv6"}, []string{"fd00::1", "fd00::7"}, false},
+		{"custom_field", discover.Config{"kind": "devices", "tag": "consul", "custom_field": "consul_role=server"}, nil, false},
+		{"invalid token", discover.Config{"token": "wrong"}, nil, true},
+		{"invalid kind", discover.Config{"kind": "racks"}, nil, true},
+		{"invalid custom_field", discover.Config{"custom_field": "server"}, nil, true},
+	}
+
+	p := &netbox.Provider{}
+	l := log.New(os.Stderr, "", log.LstdFlags)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := discover.Config{"provider": "netbox", "url": ts.URL, "token": "secret"}
+			for k, v := range tt.args {
+				args[k] = v
+			}
+			addrs, err := p.Addrs(args, l)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(addrs, tt.addrs) {
+				t.Fatalf("got %v want %v", addrs, tt.addrs)
+			}
+		})
+	}
+}
+
+func TestAddrsNextHost(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("got request for %s with Authorization %q", r.URL, r.Header.Get("Authorization"))
+		fmt.Fprint(w, `{"next": null, "results": []}`)
+	}))
+	defer other.Close()
+
+	// Netbox behind a reverse proxy on /netbox returns next pages on its
+	// own host, with or without the path of the proxy.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Token secret"; got != want {
+			http.Error(w, `{"detail": "Invalid token"}`, http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/netbox/api/dcim/devices/" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("offset") {
+		case "":
+			fmt.Fprintf(w, `{"next": "%s/api/dcim/devices/?offset=1", "results": []}`, other.URL)
+		case "1":
+			fmt.Fprintf(w, `{"next": "%s/netbox/api/dcim/devices/?offset=2", "results": [
+				{"id": 1, "name": "server-1", "status": {"value": "active"}, "primary_ip4": {"address": "10.0.0.1/24"}}
+			]}`, other.URL)
+		default:
+			fmt.Fprint(w, `{"next": null, "results": [
+				{"id": 2, "name": "server-2", "status": {"value": "active"}, "primary_ip4": {"address": "10.0.0.2/24"}}
+			]}`)
+		}
+	}))
+	defer ts.Close()
+
+	p := &netbox.Provider{}
+	args := discover.Config{"provider": "netbox", "url": ts.URL + "/netbox/", "token": "secret", "kind": "devices"}
+	addrs, err := p.Addrs(args, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1", "10.0.0.2"}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v want %v", addrs, want)
+	}
+}
+
+func TestNodes(t *testing.T) {
+	ts := testServer(t)
+	defer ts.Close()
+
+	p := &netbox.Provider{}
+	args := discover.Config{"provider": "netbox", "url": ts.URL, "token": "secret", "kind": "virtual_machines"}
+	nodes, err := p.Nodes(context.Background(), args, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []discover.Node{{
+		Addr: "10.0.1.1",
+		Name: "vm-1",
+		ID:   "7",
+		Meta: map[string]string{"kind": "virtual_machines", "status": "active"},
+	}}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Fatalf("got %+v want %+v", nodes, want)
+	}
+}
+
+func TestPing(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Token secret"; got != want {
+			http.Error(w, `{"detail": "Invalid token"}`, http.StatusForbidden)
+			return
+		}
+		if got, want := r.URL.Query().Get("limit"), "1"; got != want {
+			t.Errorf("got limit %q want %q", got, want)
+		}
+		if r.URL.Path != "/api/dcim/devices/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"next": null, "results": []}`)
+	}))
+	defer ts.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	p := &netbox.Provider{}
+	l := log.New(os.Stderr, "", log.LstdFlags)
+	valid := func() map[string]string {
+		return map[string]string{"provider": "netbox", "url": ts.URL, "token": "secret"}
+	}
+	if err := p.Ping(context.Background(), valid(), l); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []map[string]string{
+		{"url": ""},
+		{"url": "netbox.example.com"},
+		{"kind": "racks"},
+		{"address_type": "ipv5"},
+		{"custom_field": "consul_role"},
+		{"timeout": "soon"},
+		{"ca_cert": "/does/not/exist.pem"},
+		{"token": "wrong"},
+		{"url": closed.URL},
+	}
+	for _, tt := range tests {
+		args := valid()
+		for k, v := range tt {
+			args[k] = v
+		}
+		if err := p.Ping(context.Background(), args, l); err == nil {
+			t.Fatalf("%v: expected error", tt)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	var status int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(status), status)
+	}))
+	defer ts.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusForbidden, false},
+		{http.StatusNotFound, false},
+	}
+	p := &netbox.Provider{}
+	args := map[string]string{"provider": "netbox", "url": ts.URL, "token": "secret"}
+	for _, tt := range tests {
+		status = tt.status
+		_, err := p.Addrs(args, nil)
+		if err == nil {
+			t.Fatalf("%d: expected error", tt.status)
+		}
+		if got := p.Retryable(err); got != tt.retryable {
+			t.Fatalf("%d: got retryable %v want %v: %s", tt.status, got, tt.retryable, err)
+		}
+	}
+
+	args["timeout"] = "soon"
+	if _, err := p.Addrs(args, nil); err == nil || p.Retryable(err) {
+		t.Fatalf("got error %v want fatal error", err)
+	}
+	delete(args, "timeout")
+	args["url"] = closed.URL
+	if _, err := p.Addrs(args, nil); err == nil || !p.Retryable(err) {
+		t.Fatalf("got error %v want retryable error", err)
+	}
+}
@@ -0,0 +1,40 @@
+// Package provider contains the types shared by the discover package and
+// the providers.
+package provider
+
+// Node is a discovered node together with the metadata the provider knows
+// about it. Only Addr is always set. Unset fields are omitted in JSON.
+type Node struct {
+	// Addr is the address of the node including the port if the provider
+	// appends one.
+	Addr string `json:"addr"`
+
+	// Name is the name of the node, e.g. the instance name.
+	Name string `json:"name,omitempty"`
+
+	// ID is the provider specific ID of the node.
+	ID string `json:"id,omitempty"`
+
+	// Zone is the location of the node, e.g. the region, zone or
+	// datacenter.
+	Zone string `json:"zone,omitempty"`
+
+	// Meta contains further provider specific metadata like labels or
+	// tags.
+	Meta map[string]string `json:"meta,omitempty"`
+
+	// Provider is the name of the provider which discovered the node or
+	// "static" for the addresses of the static option. It is set by the
+	// discover package.
+	Provider string `json:"provider,omitempty"`
+}
+
+// Addrs returns the addresses of the nodes in order. Providers which
+// implement Nodes use it for AddrsContext.
+func Addrs(nodes []Node) []string {
+	var addrs []string
+	for _, n := range nodes {
+		addrs = append(addrs, n.Addr)
+	}
+	return addrs
+}
@@ -0,0 +1,16 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddrs(t *testing.T) {
+	if got := Addrs(nil); got != nil {
+		t.Fatalf("got %v want nil", got)
+	}
+	nodes := []Node{{Addr: "10.0.0.2", Name: "b"}, {Addr: "10.0.0.1", Name: "a"}}
+	if got, want := Addrs(nodes), []string{"10.0.0.2", "10.0.0.1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,310 @@
+// Package nomad provides node discovery for Nomad services.
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-discover/provider"
+)
+
+const (
+	// defaultAddress is the address of the local Nomad agent.
+	defaultAddress = "http://127.0.0.1:4646"
+
+	// defaultTimeout is the default timeout of the API request.
+	defaultTimeout = 10 * time.Second
+)
+
+// registration is a service registration of the Nomad service API.
+type registration struct {
+	ID          string
+	ServiceName string
+	Namespace   string
+	NodeID      string
+	Datacenter  string
+	JobID       string
+	AllocID     string
+	Tags        []string
+	Address     string
+	Port        int
+}
+
+type Provider struct {
+	userAgent string
+}
+
+func (p *Provider) SetUserAgent(s string) {
+	p.userAgent = s
+}
+
+func (p *Provider) Help() string {
+	return `Nomad:
+
+    provider:     "nomad"
+    service_name: The name of the Nomad service to discover.
+    tag:          Only return the allocations whose service registration
+                  has this tag. Optional.
+    namespace:    The Nomad namespace of the service or "*" for all
+                  namespaces. (default: "default")
+    region:       The Nomad region to query. Optional.
+    address:      The address of the Nomad API.
+                  (default: "http://127.0.0.1:4646")
+    token:        The Nomad ACL token. Optional.
+    ca_cert:      The path of the CA certificate to verify the API with.
+    client_cert:  The path of the client certificate for mTLS.
+    client_key:   The path of the client key for mTLS.
+    append_port:  "true" to append the port of the service registration to
+                  every address. (default: "false")
+    timeout:      The timeout of the API request (eg. "5s"). (default: "10s")
+
+    This uses the native service discovery of Nomad 1.3 or later, i.e.
+    services registered with provider = "nomad".
+
+    Variables can also be provided by environment variables:
+    export NOMAD_ADDR for address
+    export NOMAD_TOKEN for token
+    export NOMAD_NAMESPACE for namespace
+    export NOMAD_REGION for region
+    export NOMAD_CACERT for ca_cert
+    export NOMAD_CLIENT_CERT for client_cert
+    export NOMAD_CLIENT_KEY for client_key
+`
+}
+
+func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and aborts the API request
+// when ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
+	nodes, err := p.Nodes(ctx, args, l)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Addrs(nodes), nil
+}
+
+// config is the parsed configuration of a lookup.
+type config struct {
+	service    string
+	tag        string
+	namespace  string
+	region     string
+	address    string
+	token      string
+	appendPort bool
+	timeout    time.Duration
+	caCert     string
+	clientCert string
+	clientKey  string
+}
+
+// parseConfig parses and validates the arguments of a lookup.
+func parseConfig(args map[string]string) (*config, error) {
+	if args["provider"] != "nomad" {
+		return nil, fmt.Errorf("discover-nomad: invalid provider " + args["provider"])
+	}
+
+	c := &config{
+		service:    args["service_name"],
+		tag:        args["tag"],
+		namespace:  provider.ArgsOrEnv(args, "namespace", "NOMAD_NAMESPACE"),
+		region:     provider.ArgsOrEnv(args, "region", "NOMAD_REGION"),
+		address:    provider.ArgsOrEnv(args, "address", "NOMAD_ADDR"),
+		token:      provider.ArgsOrEnv(args, "token", "NOMAD_TOKEN"),
+		timeout:    defaultTimeout,
+		caCert:     provider.ArgsOrEnv(args, "ca_cert", "NOMAD_CACERT"),
+		clientCert: provider.ArgsOrEnv(args, "client_cert", "NOMAD_CLIENT_CERT"),
+		clientKey:  provider.ArgsOrEnv(args, "client_key", "NOMAD_CLIENT_KEY"),
+	}
+	if c.service == "" {
+		return nil, fmt.Errorf("discover-nomad: no service_name specified")
+	}
+	if c.namespace == "" {
+		c.namespace = "default"
+	}
+	if c.address == "" {
+		c.address = defaultAddress
+	}
+	if u, err := url.Parse(c.address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("discover-nomad: invalid address %q", c.address)
+	}
+
+	if v := args["append_port"]; v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("discover-nomad: invalid append_port %q", v)
+		}
+		c.appendPort = b
+	}
+
+	if v := args["timeout"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("discover-nomad: invalid timeout %q", v)
+		}
+		c.timeout = d
+	}
+	return c, nil
+}
+
+// httpClient returns the HTTP client for the TLS options of the config.
+func (c *config) httpClient() (*http.Client, error) {
+	client, err := provider.TLSClient(c.caCert, c.clientCert, c.clientKey)
+	if err != nil {
+		return nil, fmt.Errorf("discover-nomad: %s", err)
+	}
+	client.Timeout = c.timeout
+	return client, nil
+}
+
+// serviceURL returns the URL of the service registrations of the config.
+func (c *config) serviceURL() string {
+	q := url.Values{"namespace": {c.namespace}}
+	if c.region != "" {
+		q.Set("region", c.region)
+	}
+	return strings.TrimSuffix(c.address, "/") + "/v1/service/" + url.PathEscape(c.service) + "?" + q.Encode()
+}
+
+// Retryable retries network errors and responses with status 429 or 5xx,
+// see provider.Retryable.
+func (p *Provider) Retryable(err error) bool {
+	return provider.Retryable(err)
+}
+
+// Ping checks that the service registrations can be read with the token. It
+// sends the request of a lookup without processing the response so that it
+// needs the same ACL as the lookup.
+func (p *Provider) Ping(ctx context.Context, args map[string]string, l *log.Logger) error {
+	c, err := parseConfig(args)
+	if err != nil {
+		return err
+	}
+	client, err := c.httpClient()
+	if err != nil {
+		return err
+	}
+	resp, err := p.get(ctx, client, c.token, c.serviceURL())
+	if err != nil {
+		return provider.PrefixError("discover-nomad", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// get sends a GET request for u with the token and returns the response if
+// its status is 200 OK. The caller must close the response body.
+func (p *Provider) get(ctx context.Context, client *http.Client, token, u string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if token != "" {
+		req.Header.Set("X-Nomad-Token", token)
+	}
+	if p.userAgent != "" {
+		req.Header.Set("User-Agent", p.userAgent)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &provider.RequestError{Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, &provider.RequestError{
+			StatusCode: resp.StatusCode,
+			Err:        fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body))),
+		}
+	}
+	return resp, nil
+}
+
+// Nodes returns the allocations of the service together with their
+// datacenter, job and tags.
+func (p *Provider) Nodes(ctx context.Context, args map[string]string, l *log.Logger) ([]provider.Node, error) {
+	c, err := parseConfig(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if l == nil {
+		l = log.New(ioutil.Discard, "", 0)
+	}
+
+	client, err := c.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	service, tag, namespace, region := c.service, c.tag, c.namespace, c.region
+	l.Printf("[DEBUG] discover-nomad: Using service_name=%s tag=%s namespace=%s region=%s", service, tag, namespace, region)
+
+	resp, err := p.get(ctx, client, c.token, c.serviceURL())
+	if err != nil {
+		return nil, provider.PrefixError("discover-nomad", err)
+	}
+	defer resp.Body.Close()
+
+	var regs []registration
+	if err := json.NewDecoder(resp.Body).Decode(&regs); err != nil {
+		return nil, fmt.Errorf("discover-nomad: invalid response: %s", err)
+	}
+
+	var nodes []provider.Node
+	for _, r := range regs {
+		if tag != "" && !hasTag(r.Tags, tag) {
+			l.Printf("[DEBUG] discover-nomad: allocation %s does not have tag %s", r.AllocID, tag)
+			continue
+		}
+		if r.Address == "" {
+			l.Printf("[DEBUG] discover-nomad: allocation %s has no address", r.AllocID)
+			continue
+		}
+
+		addr := r.Address
+		if c.appendPort && r.Port != 0 {
+			addr = net.JoinHostPort(addr, strconv.Itoa(r.Port))
+		}
+		nodes = append(nodes, provider.Node{
+			Addr: addr,
+			Name: r.AllocID,
+			ID:   r.ID,
+			Zone: r.Datacenter,
+			Meta: map[string]string{
+				"namespace": r.Namespace,
+				"job":       r.JobID,
+				"node_id":   r.NodeID,
+				"port":      strconv.Itoa(r.Port),
+				"tags":      strings.Join(r.Tags, ","),
+			},
+		})
+	}
+
+	l.Printf("[DEBUG] discover-nomad: Found %d allocations of service %s", len(nodes), service)
+	return nodes, nil
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package nomad_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	discover "github.com/hashicorp/go-discover"
+	"github.com/hashicorp/go-discover/provider/nomad"
+)
+
+var _ discover.Provider = (*nomad.Provider)(nil)
+var _ discover.ProviderWithUserAgent = (*nomad.Provider)(nil)
+var _ discover.NodeProvider = (*nomad.Provider)(nil)
+var _ discover.ProviderWithPing = (*nomad.Provider)(nil)
+var _ discover.ProviderWithRetryable = (*nomad.Provider)(nil)
+
+const services = `[
+  {"ID": "_nomad-task-1", "ServiceName": "consul", "Namespace": "default", "NodeID": "n1", "Datacenter": "dc1", "JobID": "consul", "AllocID": "a1", "Tags": ["server"], "Address": "10.0.0.1", "Port": 8301},
+  {"ID": "_nomad-task-2", "ServiceName": "consul", "Namespace": "default", "NodeID": "n2", "Datacenter": "dc2", "JobID": "consul", "AllocID": "a2", "Tags": ["client"], "Address": "10.0.0.2", "Port": 8301},
+  {"ID": "_nomad-task-3", "ServiceName": "consul", "Namespace": "default", "NodeID": "n3", "Datacenter": "dc1", "JobID": "consul", "AllocID": "a3", "Tags": ["server"], "Address": "fd00::3", "Port": 8301}
+]`
+
+func TestAddrs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/service/consul" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.Header.Get("X-Nomad-Token"); got != "secret" {
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+		if got, want := r.URL.Query().Get("namespace"), "default"; got != want {
+			http.Error(w, fmt.Sprintf("got namespace %q want %q", got, want), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, services)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name  string
+		args  discover.Config
+		addrs []string
+		err   bool
+	}{
+		{"all", discover.Config{}, []string{"10.0.0.1", "10.0.0.2", "fd00::3"}, false},
+		{"tag", discover.Config{"tag": "server"}, []string{"10.0.0.1", "fd00::3"}, false},
+		{"append_port", discover.Config{"tag": "server", "append_port": "true"}, []string{"10.0.0.1:8301", "[fd00::3]:8301"}, false},
+		{"unknown service", discover.Config{"service_name": "vault"}, nil, true},
+		{"invalid token", discover.Config{"token": "wrong"}, nil, true},
+		{"invalid append_port", discover.Config{"append_port": "yes please"}, nil, true},
+	}
+
+	p := &nomad.Provider{}
+	l := log.New(os.Stderr, "", log.LstdFlags)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := discover.Config{"provider": "nomad", "address": ts.URL, "service_name": "consul", "token": "secret"}
+			for k, v := range tt.args {
+				args[k] = v
+			}
+			addrs, err := p.Addrs(args, l)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(addrs, tt.addrs) {
+				t.Fatalf("got %v want %v", addrs, tt.addrs)
+			}
+		})
+	}
+}
+
+func TestNodes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, services)
+	}))
+	defer ts.Close()
+
+	p := &nomad.Provider{}
+	args := discover.Config{"provider": "nomad", "address": ts.URL, "service_name": "consul", "tag": "client"}
+	nodes, err := p.Nodes(context.Background(), args, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []discover.Node{{
+		Addr: "10.0.0.2",
+		Name: "a2",
+		ID:   "_nomad-task-2",
+		Zone: "dc2",
+		Meta: map[string]string{"namespace": "default", "job": "consul", "node_id": "n2", "port": "8301", "tags": "client"},
+	}}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Fatalf("got %+v want %+v", nodes, want)
+	}
+}
+
+func TestPing(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Nomad-Token"); got != "secret" {
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, services)
+	}))
+	defer ts.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	p := &nomad.Provider{}
+	l := log.New(os.Stderr, "", log.LstdFlags)
+	valid := func() map[string]string {
+		return map[string]string{"provider": "nomad", "address": ts.URL, "service_name": "consul", "token": "secret"}
+	}
+	if err := p.Ping(context.Background(), valid(), l); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []map[string]string{
+		{"service_name": ""},
+		{"append_port": "maybe"},
+		{"timeout": "soon"},
+		{"address": "127.0.0.1:4646"},
+		{"ca_cert": "/nonexistent/ca.pem"},
+		{"token": "wrong"},
+		{"address": closed.URL},
+	}
+	for _, tt := range tests {
+		args := valid()
+		for k, v := range tt {
+			args[k] = v
+		}
+		if err := p.Ping(context.Background(), args, l); err == nil {
+			t.Fatalf("%v: expected error", tt)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	var status int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(status), status)
+	}))
+	defer ts.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusForbidden, false},
+		{http.StatusNotFound, false},
+	}
+	p := &nomad.Provider{}
+	args := map[string]string{"provider": "nomad", "address": ts.URL, "service_name": "consul"}
+	for _, tt := range tests {
+		status = tt.status
+		_, err := p.Addrs(args, nil)
+		if err == nil {
+			t.Fatalf("%d: expected error", tt.status)
+		}
+		if got := p.Retryable(err); got != tt.retryable {
+			t.Fatalf("%d: got retryable %v want %v: %s", tt.status, got, tt.retryable, err)
+		}
+	}
+
+	args["timeout"] = "soon"
+	if _, err := p.Addrs(args, nil); err == nil || p.Retryable(err) {
+		t.Fatalf("got error %v want fatal error", err)
+	}
+	delete(args, "timeout")
+	args["address"] = closed.URL
+	if _, err := p.Addrs(args, nil); err == nil || !p.Retryable(err) {
+		t.Fatalf("got error %v want retryable error", err)
+	}
+}
@@ -2,6 +2,7 @@
 package os
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -44,6 +45,12 @@ func (p *Provider) Help() string {
 }
 
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and aborts the metadata,
+// identity and compute requests when ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
 	if args["provider"] != "os" {
 		return nil, fmt.Errorf("discover-os: invalid provider " + args["provider"])
 	}
@@ -59,7 +66,7 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 
 	if projectID == "" { // Use the one on the instance if not provided either by parameter or env
 		l.Printf("[INFO] discover-os: ProjectID not provided. Looking up in metadata...")
-		projectID, err = getProjectID()
+		projectID, err = getProjectID(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -68,7 +75,7 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 	}
 
 	log.Printf("[DEBUG] discover-os: Using project_id=%s tag_key=%s tag_value=%s", projectID, tagKey, tagValue)
-	client, err := newClient(args, l)
+	client, err := newClient(ctx, args, l)
 	if err != nil {
 		return nil, err
 	}
@@ -117,7 +124,7 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 	return addrs, nil
 }
 
-func newClient(args map[string]string, l *log.Logger) (*gophercloud.ServiceClient, error) {
+func newClient(ctx context.Context, args map[string]string, l *log.Logger) (*gophercloud.ServiceClient, error) {
 	username := argsOrEnv(args, "user_name", "OS_USERNAME")
 	password := argsOrEnv(args, "password", "OS_PASSWORD")
 	token := argsOrEnv(args, "token", "OS_AUTH_TOKEN")
@@ -149,6 +156,7 @@ func newClient(args map[string]string, l *log.Logger) (*gophercloud.ServiceClien
 	if err != nil {
 		return nil, fmt.Errorf("discover-os: Client initialization failed: %s", err)
 	}
+	client.Context = ctx
 
 	config := &tls.Config{InsecureSkipVerify: insecure != ""}
 	transport := &http.Transport{
@@ -200,8 +208,12 @@ func (opts ListOpts) ToServerListQuery() (string, error) {
 	return q.String(), err
 }
 
-func getProjectID() (string, error) {
-	resp, err := http.Get("http://169.254.169.254/openstack/latest/meta_data.json")
+func getProjectID(ctx context.Context) (string, error) {
+	req, err := http.NewRequest("GET", "http://169.254.169.254/openstack/latest/meta_data.json", nil)
+	if err != nil {
+		return "", fmt.Errorf("discover-os: Error asking metadata for project_id: %s", err)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return "", fmt.Errorf("discover-os: Error asking metadata for project_id: %s", err)
 	}
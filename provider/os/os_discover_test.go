@@ -11,6 +11,7 @@ import (
 
 var _ discover.Provider = (*openstack.Provider)(nil)
 var _ discover.ProviderWithUserAgent = (*openstack.Provider)(nil)
+var _ discover.ProviderWithContext = (*openstack.Provider)(nil)
 
 func TestAddrs(t *testing.T) {
 	// todo: maybe check for http://169.254.169.254/openstack/latest/meta_data.json first
@@ -1,11 +1,13 @@
 package packet
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"strings"
 
+	"github.com/hashicorp/go-discover/provider"
 	"github.com/packethost/packngo"
 )
 
@@ -41,6 +43,20 @@ func (p *Provider) Help() string {
 
 // Addrs function
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and returns when ctx is
+// done. The packngo client does not accept a context, so the requests of an
+// abandoned lookup keep running until they return.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
+	return provider.LookupContext(ctx, func() ([]string, error) {
+		return p.addrs(args, l)
+	})
+}
+
+// addrs looks up the addresses without a context.
+func (p *Provider) addrs(args map[string]string, l *log.Logger) ([]string, error) {
 	authToken := argsOrEnv(args, "auth_token", "PACKET_AUTH_TOKEN")
 	projectID := argsOrEnv(args, "project", "PACKET_PROJECT")
 	packetURL := argsOrEnv(args, "url", "PACKET_URL")
@@ -11,6 +11,7 @@ import (
 
 var _ discover.Provider = (*packet.Provider)(nil)
 var _ discover.ProviderWithUserAgent = (*packet.Provider)(nil)
+var _ discover.ProviderWithContext = (*packet.Provider)(nil)
 
 func TestAddrsDefault(t *testing.T) {
 	args := discover.Config{
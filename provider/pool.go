@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// ForEach calls fn for every index from 0 to n-1 with at most concurrency
+// calls running at the same time. It returns when all calls have returned.
+// A concurrency below 1 runs the calls one after another.
+func ForEach(n, concurrency int, fn func(i int)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// Concurrency returns the maximum number of concurrent requests from the
+// concurrency option of args or def if it is not set.
+func Concurrency(args map[string]string, def int) (int, error) {
+	v := args["concurrency"]
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid concurrency %q", v)
+	}
+	return n, nil
+}
+
+// concurrencyKey is the context key of the default concurrency.
+type concurrencyKey struct{}
+
+// WithConcurrency returns a copy of ctx which carries n as the default
+// maximum number of concurrent requests of a lookup. The concurrency is
+// passed with the context instead of being set on the provider since one
+// provider may run several lookups with different limits at the same time.
+func WithConcurrency(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, concurrencyKey{}, n)
+}
+
+// ContextConcurrency returns the default maximum number of concurrent
+// requests carried by ctx or def if ctx has none.
+func ContextConcurrency(ctx context.Context, def int) int {
+	if n, ok := ctx.Value(concurrencyKey{}).(int); ok && n > 0 {
+		return n
+	}
+	return def
+}
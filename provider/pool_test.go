@@ -0,0 +1,76 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForEach(t *testing.T) {
+	for _, concurrency := range []int{0, 1, 3} {
+		var mu sync.Mutex
+		var running, max int
+		done := make([]bool, 10)
+		ForEach(len(done), concurrency, func(i int) {
+			mu.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			done[i] = true
+			mu.Unlock()
+		})
+
+		want := concurrency
+		if want < 1 {
+			want = 1
+		}
+		if max > want {
+			t.Fatalf("concurrency %d: got %d concurrent calls want at most %d", concurrency, max, want)
+		}
+		for i, ok := range done {
+			if !ok {
+				t.Fatalf("concurrency %d: fn not called for %d", concurrency, i)
+			}
+		}
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	tests := []struct {
+		args map[string]string
+		n    int
+		err  bool
+	}{
+		{map[string]string{}, 4, false},
+		{map[string]string{"concurrency": "16"}, 16, false},
+		{map[string]string{"concurrency": "0"}, 0, true},
+		{map[string]string{"concurrency": "many"}, 0, true},
+	}
+	for _, tt := range tests {
+		n, err := Concurrency(tt.args, 4)
+		if (err != nil) != tt.err || n != tt.n {
+			t.Fatalf("%v: got %d, %v want %d, error %v", tt.args, n, err, tt.n, tt.err)
+		}
+	}
+}
+
+func TestContextConcurrency(t *testing.T) {
+	ctx := context.Background()
+	if got := ContextConcurrency(ctx, 4); got != 4 {
+		t.Fatalf("got %d want default 4", got)
+	}
+	if got := ContextConcurrency(WithConcurrency(ctx, 8), 4); got != 8 {
+		t.Fatalf("got %d want 8", got)
+	}
+	if got := ContextConcurrency(WithConcurrency(ctx, 0), 4); got != 4 {
+		t.Fatalf("got %d want default 4", got)
+	}
+}
@@ -0,0 +1,407 @@
+// Package proxmox provides node discovery for Proxmox VE.
+package proxmox
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-discover/provider"
+)
+
+// defaultTimeout is the default timeout of a lookup.
+const defaultTimeout = 30 * time.Second
+
+// guest is a VM or container of the cluster resources API.
+type guest struct {
+	Type   string `json:"type"`
+	VMID   int    `json:"vmid"`
+	Name   string `json:"name"`
+	Node   string `json:"node"`
+	Status string `json:"status"`
+	Pool   string `json:"pool"`
+	Tags   string `json:"tags"`
+}
+
+// agentInterface is a network interface reported by the QEMU guest agent.
+type agentInterface struct {
+	Name        string `json:"name"`
+	IPAddresses []struct {
+		Address string `json:"ip-address"`
+	} `json:"ip-addresses"`
+}
+
+// lxcInterface is a network interface of a container.
+type lxcInterface struct {
+	Name  string `json:"name"`
+	Inet  string `json:"inet"`
+	Inet6 string `json:"inet6"`
+}
+
+type Provider struct {
+	userAgent string
+}
+
+func (p *Provider) SetUserAgent(s string) {
+	p.userAgent = s
+}
+
+func (p *Provider) Help() string {
+	return `Proxmox VE:
+
+    provider:     "proxmox"
+    url:          The URL of the Proxmox VE API, e.g. "https://pve.example.com:8006".
+    token_id:     The ID of the API token, e.g. "discover@pve!consul".
+    token_secret: The secret of the API token.
+    pool:         Only return the guests in this pool. Optional.
+    tag:          Only return the guests with this tag. Optional.
+    name_prefix:  Only return the guests whose name starts with this prefix. Optional.
+    type:         "qemu", "lxc" or "all". (default: "all")
+    address_type: "ipv4" or "ipv6". (default: "ipv4")
+    interface:    Only return the addresses of this network interface, e.g.
+                  "eth0". Optional.
+    ca_cert:      The path of the CA certificate to verify the API with, e.g.
+                  the pve-root-ca.pem of the cluster. Optional.
+    insecure_ssl: "true" to skip the verification of the API certificate.
+                  Cannot be used with ca_cert. (default: "false")
+    timeout:      The timeout of the lookup (eg. "10s"). (default: "30s")
+
+    Only running guests are returned. The addresses of VMs are reported by
+    the QEMU guest agent, so VMs without a running agent are skipped.
+    Loopback and link-local addresses are ignored. The token needs the
+    VM.Audit and VM.Monitor privileges.
+
+    Variables can also be provided by environment variables:
+    export PROXMOX_URL for url
+    export PROXMOX_TOKEN_ID for token_id
+    export PROXMOX_TOKEN_SECRET for token_secret
+    export PROXMOX_CACERT for ca_cert
+`
+}
+
+func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and aborts the API requests
+// when ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
+	nodes, err := p.Nodes(ctx, args, l)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Addrs(nodes), nil
+}
+
+// config is the parsed configuration of a lookup.
+type config struct {
+	url           string
+	tokenID       string
+	tokenSecret   string
+	pool          string
+	tag           string
+	namePrefix    string
+	interfaceName string
+	guestType     string
+	addressType   string
+	timeout       time.Duration
+	client        *http.Client
+}
+
+// parseConfig parses and validates the arguments of a lookup.
+func parseConfig(args map[string]string) (*config, error) {
+	if args["provider"] != "proxmox" {
+		return nil, fmt.Errorf("discover-proxmox: invalid provider " + args["provider"])
+	}
+
+	c := &config{
+		url:           provider.ArgsOrEnv(args, "url", "PROXMOX_URL"),
+		tokenID:       provider.ArgsOrEnv(args, "token_id", "PROXMOX_TOKEN_ID"),
+		tokenSecret:   provider.ArgsOrEnv(args, "token_secret", "PROXMOX_TOKEN_SECRET"),
+		pool:          args["pool"],
+		tag:           args["tag"],
+		namePrefix:    args["name_prefix"],
+		interfaceName: args["interface"],
+		guestType:     args["type"],
+		addressType:   args["address_type"],
+		timeout:       defaultTimeout,
+	}
+	if c.url == "" {
+		return nil, fmt.Errorf("discover-proxmox: no url specified")
+	}
+	if u, err := url.Parse(c.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("discover-proxmox: invalid url %q", c.url)
+	}
+	if c.tokenID == "" || c.tokenSecret == "" {
+		return nil, fmt.Errorf("discover-proxmox: no token_id or token_secret specified")
+	}
+
+	switch c.guestType {
+	case "":
+		c.guestType = "all"
+	case "all", "qemu", "lxc":
+	default:
+		return nil, fmt.Errorf("discover-proxmox: invalid type %q", c.guestType)
+	}
+
+	switch c.addressType {
+	case "":
+		c.addressType = "ipv4"
+	case "ipv4", "ipv6":
+	default:
+		return nil, fmt.Errorf("discover-proxmox: invalid address_type %q", c.addressType)
+	}
+
+	insecure := false
+	if v := args["insecure_ssl"]; v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("discover-proxmox: invalid insecure_ssl %q", v)
+		}
+		insecure = b
+	}
+
+	if v := args["timeout"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("discover-proxmox: invalid timeout %q", v)
+		}
+		c.timeout = d
+	}
+
+	caCert := provider.ArgsOrEnv(args, "ca_cert", "PROXMOX_CACERT")
+	if caCert != "" && insecure {
+		return nil, fmt.Errorf("discover-proxmox: ca_cert and insecure_ssl cannot be used together")
+	}
+	client, err := provider.TLSClient(caCert, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("discover-proxmox: %s", err)
+	}
+	if insecure {
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+	c.client = client
+	return c, nil
+}
+
+// newClient returns the API client for the config.
+func (cfg *config) newClient(userAgent string) *client {
+	return &client{
+		url:       strings.TrimSuffix(cfg.url, "/") + "/api2/json",
+		auth:      "PVEAPIToken=" + cfg.tokenID + "=" + cfg.tokenSecret,
+		userAgent: userAgent,
+		http:      cfg.client,
+	}
+}
+
+// Retryable retries network errors and responses with status 429 or 5xx,
+// see provider.Retryable.
+func (p *Provider) Retryable(err error) bool {
+	return provider.Retryable(err)
+}
+
+// Ping checks that the API can be reached with the token by fetching the
+// API version, which every valid token may read.
+func (p *Provider) Ping(ctx context.Context, args map[string]string, l *log.Logger) error {
+	cfg, err := parseConfig(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err := cfg.newClient(p.userAgent).get(ctx, "/version", &version); err != nil {
+		return provider.PrefixError("discover-proxmox", err)
+	}
+	return nil
+}
+
+// Nodes returns the addresses of the matching guests together with their
+// name, VM ID, cluster node, pool and tags.
+func (p *Provider) Nodes(ctx context.Context, args map[string]string, l *log.Logger) ([]provider.Node, error) {
+	cfg, err := parseConfig(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if l == nil {
+		l = log.New(ioutil.Discard, "", 0)
+	}
+
+	pool, tag, namePrefix, interfaceName := cfg.pool, cfg.tag, cfg.namePrefix, cfg.interfaceName
+	guestType, addressType := cfg.guestType, cfg.addressType
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+
+	l.Printf("[DEBUG] discover-proxmox: Using pool=%s tag=%s name_prefix=%s type=%s address_type=%s", pool, tag, namePrefix, guestType, addressType)
+
+	c := cfg.newClient(p.userAgent)
+
+	var guests []guest
+	if err := c.get(ctx, "/cluster/resources?type=vm", &guests); err != nil {
+		return nil, provider.PrefixError("discover-proxmox", err)
+	}
+
+	var nodes []provider.Node
+	for _, g := range guests {
+		switch {
+		case guestType != "all" && g.Type != guestType:
+			continue
+		case g.Status != "running":
+			l.Printf("[DEBUG] discover-proxmox: guest %s (%d) is %s", g.Name, g.VMID, g.Status)
+			continue
+		case pool != "" && g.Pool != pool:
+			continue
+		case tag != "" && !hasTag(g.Tags, tag):
+			continue
+		case namePrefix != "" && !strings.HasPrefix(g.Name, namePrefix):
+			continue
+		}
+
+		ips, err := c.guestIPs(ctx, g, interfaceName)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, provider.PrefixError("discover-proxmox", err)
+			}
+			l.Printf("[DEBUG] discover-proxmox: ignoring guest %s (%d): %s", g.Name, g.VMID, err)
+			continue
+		}
+
+		for _, ip := range ips {
+			if !usableIP(ip, addressType) {
+				continue
+			}
+			nodes = append(nodes, provider.Node{
+				Addr: ip.String(),
+				Name: g.Name,
+				ID:   strconv.Itoa(g.VMID),
+				Zone: g.Node,
+				Meta: map[string]string{"type": g.Type, "pool": g.Pool, "tags": g.Tags},
+			})
+		}
+	}
+
+	l.Printf("[DEBUG] discover-proxmox: Found %d addresses", len(nodes))
+	return nodes, nil
+}
+
+// client is a minimal client of the Proxmox VE API.
+type client struct {
+	url       string
+	auth      string
+	userAgent string
+	http      *http.Client
+}
+
+// get decodes the data of the API response for path into v.
+func (c *client) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequest("GET", c.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", c.auth)
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return &provider.RequestError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &provider.RequestError{StatusCode: resp.StatusCode, Err: fmt.Errorf("GET %s: %s", path, resp.Status)}
+	}
+
+	body := struct {
+		Data interface{} `json:"data"`
+	}{v}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("GET %s: invalid response: %s", path, err)
+	}
+	return nil
+}
+
+// guestIPs returns the IP addresses of the guest or of its interface with
+// the given name if it is set.
+func (c *client) guestIPs(ctx context.Context, g guest, interfaceName string) ([]net.IP, error) {
+	path := "/nodes/" + url.PathEscape(g.Node) + "/" + g.Type + "/" + strconv.Itoa(g.VMID)
+
+	var ips []net.IP
+	switch g.Type {
+	case "qemu":
+		var resp struct {
+			Result []agentInterface `json:"result"`
+		}
+		if err := c.get(ctx, path+"/agent/network-get-interfaces", &resp); err != nil {
+			return nil, err
+		}
+		for _, iface := range resp.Result {
+			if interfaceName != "" && iface.Name != interfaceName {
+				continue
+			}
+			for _, a := range iface.IPAddresses {
+				if ip := net.ParseIP(a.Address); ip != nil {
+					ips = append(ips, ip)
+				}
+			}
+		}
+
+	case "lxc":
+		var ifaces []lxcInterface
+		if err := c.get(ctx, path+"/interfaces", &ifaces); err != nil {
+			return nil, err
+		}
+		for _, iface := range ifaces {
+			if interfaceName != "" && iface.Name != interfaceName {
+				continue
+			}
+			for _, cidr := range []string{iface.Inet, iface.Inet6} {
+				if ip, _, err := net.ParseCIDR(cidr); err == nil {
+					ips = append(ips, ip)
+				}
+			}
+		}
+	}
+	return ips, nil
+}
+
+// usableIP returns true for the global and private addresses of the
+// address type.
+func usableIP(ip net.IP, addressType string) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return false
+	}
+	isV4 := ip.To4() != nil
+	return isV4 == (addressType == "ipv4")
+}
+
+// hasTag returns true if tag is one of the tags of a guest which are
+// separated by semicolons, commas or spaces.
+func hasTag(tags, tag string) bool {
+	for _, t := range strings.FieldsFunc(tags, func(r rune) bool { return r == ';' || r == ',' || r == ' ' }) {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
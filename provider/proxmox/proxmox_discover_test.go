@@ -0,0 +1,255 @@
+package proxmox_test
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	discover "github.com/hashicorp/go-discover"
+	"github.com/hashicorp/go-discover/provider/proxmox"
+)
+
+var _ discover.Provider = (*proxmox.Provider)(nil)
+var _ discover.ProviderWithUserAgent = (*proxmox.Provider)(nil)
+var _ discover.NodeProvider = (*proxmox.Provider)(nil)
+var _ discover.ProviderWithPing = (*proxmox.Provider)(nil)
+var _ discover.ProviderWithRetryable = (*proxmox.Provider)(nil)
+
+// responses are the API responses by path.
+var responses = map[string]string{
+	"/api2/json/version": `{"data": {"version": "8.1.4", "release": "8.1"}}`,
+	"/api2/json/cluster/resources": `{"data": [
+		{"id": "qemu/100", "type": "qemu", "vmid": 100, "name": "consul-1", "node": "pve1", "status": "running", "pool": "consul", "tags": "server;prod"},
+		{"id": "qemu/101", "type": "qemu", "vmid": 101, "name": "consul-2", "node": "pve2", "status": "running", "pool": "consul", "tags": "server"},
+		{"id": "qemu/102", "type": "qemu", "vmid": 102, "name": "consul-3", "node": "pve2", "status": "stopped", "pool": "consul", "tags": "server"},
+		{"id": "qemu/103", "type": "qemu", "vmid": 103, "name": "no-agent", "node": "pve1", "status": "running", "pool": "consul"},
+		{"id": "lxc/200", "type": "lxc", "vmid": 200, "name": "web-1", "node": "pve1", "status": "running", "tags": "prod"}
+	]}`,
+	"/api2/json/nodes/pve1/qemu/100/agent/network-get-interfaces": `{"data": {"result": [
+		{"name": "lo", "ip-addresses": [{"ip-address": "127.0.0.1", "ip-address-type": "ipv4"}, {"ip-address": "::1", "ip-address-type": "ipv6"}]},
+		{"name": "eth0", "ip-addresses": [{"ip-address": "10.0.0.1", "ip-address-type": "ipv4"}, {"ip-address": "fe80::1", "ip-address-type": "ipv6"}, {"ip-address": "fd00::1", "ip-address-type": "ipv6"}]},
+		{"name": "eth1", "ip-addresses": [{"ip-address": "192.168.0.1", "ip-address-type": "ipv4"}]}
+	]}}`,
+	"/api2/json/nodes/pve2/qemu/101/agent/network-get-interfaces": `{"data": {"result": [
+		{"name": "eth0", "ip-addresses": [{"ip-address": "10.0.0.2", "ip-address-type": "ipv4"}]}
+	]}}`,
+	"/api2/json/nodes/pve1/lxc/200/interfaces": `{"data": [
+		{"name": "lo", "inet": "127.0.0.1/8", "inet6": "::1/128"},
+		{"name": "eth0", "inet": "10.0.1.1/24", "inet6": "fd00::200/64"}
+	]}`,
+}
+
+func testServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "PVEAPIToken=discover@pve!test=secret"; got != want {
+			http.Error(w, "authentication failure", http.StatusUnauthorized)
+			return
+		}
+		resp, ok := responses[r.URL.Path]
+		if !ok {
+			// like a VM without a running guest agent
+			http.Error(w, "QEMU guest agent is not running", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, resp)
+	}))
+}
+
+func TestAddrs(t *testing.T) {
+	ts := testServer(t)
+	defer ts.Close()
+
+	tests := []struct {
+		name  string
+		args  discover.Config
+		addrs []string
+		err   bool
+	}{
+		{"all", discover.Config{}, []string{"10.0.0.1", "192.168.0.1", "10.0.0.2", "10.0.1.1"}, false},
+		{"pool", discover.Config{"pool": "consul"}, []string{"10.0.0.1", "192.168.0.1", "10.0.0.2"}, false},
+		{"tag", discover.Config{"tag": "prod"}, []string{"10.0.0.1", "192.168.0.1", "10.0.1.1"}, false},
+		{"name_prefix", discover.Config{"name_prefix": "web-"}, []string{"10.0.1.1"}, false},
+		{"type", discover.Config{"type": "qemu", "interface": "eth0"}, []string{"10.0.0.1", "10.0.0.2"}, false},
+		{"ipv6", discover.Config{"address_type": "ipv6"}, []string{"fd00::1", "fd00::200"}, false},
+		{"invalid token", discover.Config{"token_secret": "wrong"}, nil, true},
+		{"invalid type", discover.Config{"type": "vm"}, nil, true},
+		{"invalid address_type", discover.Config{"address_type": "public_v4"}, nil, true},
+	}
+
+	p := &proxmox.Provider{}
+	l := log.New(os.Stderr, "", log.LstdFlags)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := discover.Config{"provider": "proxmox", "url": ts.URL, "token_id": "discover@pve!test", "token_secret": "secret"}
+			for k, v := range tt.args {
+				args[k] = v
+			}
+			addrs, err := p.Addrs(args, l)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(addrs, tt.addrs) {
+				t.Fatalf("got %v want %v", addrs, tt.addrs)
+			}
+		})
+	}
+}
+
+func TestNodes(t *testing.T) {
+	ts := testServer(t)
+	defer ts.Close()
+
+	p := &proxmox.Provider{}
+	args := discover.Config{"provider": "proxmox", "url": ts.URL, "token_id": "discover@pve!test", "token_secret": "secret", "type": "lxc"}
+	nodes, err := p.Nodes(context.Background(), args, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []discover.Node{{
+		Addr: "10.0.1.1",
+		Name: "web-1",
+		ID:   "200",
+		Zone: "pve1",
+		Meta: map[string]string{"type": "lxc", "pool": "", "tags": "prod"},
+	}}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Fatalf("got %+v want %+v", nodes, want)
+	}
+}
+
+func TestPing(t *testing.T) {
+	ts := testServer(t)
+	defer ts.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	p := &proxmox.Provider{}
+	l := log.New(os.Stderr, "", log.LstdFlags)
+	valid := func() map[string]string {
+		return map[string]string{"provider": "proxmox", "url": ts.URL, "token_id": "discover@pve!test", "token_secret": "secret"}
+	}
+	if err := p.Ping(context.Background(), valid(), l); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []map[string]string{
+		{"url": "pve.example.com"},
+		{"token_secret": ""},
+		{"type": "vm"},
+		{"address_type": "ipv5"},
+		{"insecure_ssl": "maybe"},
+		{"ca_cert": "/nonexistent/ca.pem"},
+		{"timeout": "soon"},
+		{"token_secret": "wrong"},
+		{"url": closed.URL},
+	}
+	for _, tt := range tests {
+		args := valid()
+		for k, v := range tt {
+			args[k] = v
+		}
+		if err := p.Ping(context.Background(), args, l); err == nil {
+			t.Fatalf("%v: expected error", tt)
+		}
+	}
+}
+
+func TestPingTLS(t *testing.T) {
+	plain := testServer(t)
+	defer plain.Close()
+	ts := httptest.NewTLSServer(plain.Config.Handler)
+	defer ts.Close()
+
+	f, err := ioutil.TempFile("", "discover-proxmox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	err = pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args map[string]string
+		ok   bool
+	}{
+		{"unknown certificate", nil, false},
+		{"ca_cert", map[string]string{"ca_cert": f.Name()}, true},
+		{"insecure_ssl", map[string]string{"insecure_ssl": "true"}, true},
+		{"ca_cert and insecure_ssl", map[string]string{"ca_cert": f.Name(), "insecure_ssl": "true"}, false},
+	}
+	p := &proxmox.Provider{}
+	for _, tt := range tests {
+		args := map[string]string{"provider": "proxmox", "url": ts.URL, "token_id": "discover@pve!test", "token_secret": "secret"}
+		for k, v := range tt.args {
+			args[k] = v
+		}
+		err := p.Ping(context.Background(), args, nil)
+		if tt.ok && err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Fatalf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	var status int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(status), status)
+	}))
+	defer ts.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusForbidden, false},
+		{http.StatusNotFound, false},
+	}
+	p := &proxmox.Provider{}
+	args := map[string]string{"provider": "proxmox", "url": ts.URL, "token_id": "discover@pve!test", "token_secret": "secret"}
+	for _, tt := range tests {
+		status = tt.status
+		_, err := p.Addrs(args, nil)
+		if err == nil {
+			t.Fatalf("%d: expected error", tt.status)
+		}
+		if got := p.Retryable(err); got != tt.retryable {
+			t.Fatalf("%d: got retryable %v want %v: %s", tt.status, got, tt.retryable, err)
+		}
+	}
+
+	args["timeout"] = "soon"
+	if _, err := p.Addrs(args, nil); err == nil || p.Retryable(err) {
+		t.Fatalf("got error %v want fatal error", err)
+	}
+	delete(args, "timeout")
+	args["url"] = closed.URL
+	if _, err := p.Addrs(args, nil); err == nil || !p.Retryable(err) {
+		t.Fatalf("got error %v want retryable error", err)
+	}
+}
@@ -0,0 +1,41 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// RequestError is an HTTP request of a provider which failed without a
+// response or with an unexpected status.
+type RequestError struct {
+	// StatusCode is the status of the response or 0 if there was none,
+	// e.g. because the server could not be reached.
+	StatusCode int
+
+	// Err describes the failure.
+	Err error
+}
+
+func (e *RequestError) Error() string { return e.Err.Error() }
+
+// PrefixError prefixes the message of err with prefix, e.g.
+// "discover-nomad". A *RequestError stays one so that Retryable can
+// classify it.
+func PrefixError(prefix string, err error) error {
+	if e, ok := err.(*RequestError); ok {
+		return &RequestError{StatusCode: e.StatusCode, Err: fmt.Errorf("%s: %s", prefix, e.Err)}
+	}
+	return fmt.Errorf("%s: %s", prefix, err)
+}
+
+// Retryable reports whether err is a *RequestError which may succeed when
+// it is retried. These are requests without a response, e.g. because of a
+// network error, and responses with status 429 or 5xx. All other errors,
+// like invalid arguments or rejected credentials, are fatal.
+func Retryable(err error) bool {
+	e, ok := err.(*RequestError)
+	if !ok {
+		return false
+	}
+	return e.StatusCode == 0 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
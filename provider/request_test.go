@@ -0,0 +1,33 @@
+package provider
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{&RequestError{Err: errors.New("connection refused")}, true},
+		{&RequestError{StatusCode: 429, Err: errors.New("429 Too Many Requests")}, true},
+		{&RequestError{StatusCode: 503, Err: errors.New("503 Service Unavailable")}, true},
+		{&RequestError{StatusCode: 401, Err: errors.New("401 Unauthorized")}, false},
+		{&RequestError{StatusCode: 404, Err: errors.New("404 Not Found")}, false},
+		{errors.New("invalid url"), false},
+	}
+	for _, tt := range tests {
+		if got := Retryable(tt.err); got != tt.retryable {
+			t.Fatalf("%v: got %v want %v", tt.err, got, tt.retryable)
+		}
+		// the prefix keeps the classification
+		err := PrefixError("discover-test", tt.err)
+		if got := Retryable(err); got != tt.retryable {
+			t.Fatalf("%v: got %v want %v", err, got, tt.retryable)
+		}
+		if want := "discover-test: " + tt.err.Error(); err.Error() != want {
+			t.Fatalf("got %q want %q", err, want)
+		}
+	}
+}
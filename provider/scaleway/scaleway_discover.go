@@ -2,10 +2,12 @@
 package scaleway
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 
+	"github.com/hashicorp/go-discover/provider"
 	api "github.com/nicolai86/scaleway-sdk"
 )
 
@@ -23,6 +25,20 @@ func (p *Provider) Help() string {
 }
 
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and returns when ctx is
+// done. The Scaleway SDK has no context support, so the server list of an
+// abandoned lookup is not aborted.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
+	return provider.LookupContext(ctx, func() ([]string, error) {
+		return p.addrs(args, l)
+	})
+}
+
+// addrs looks up the addresses without a context.
+func (p *Provider) addrs(args map[string]string, l *log.Logger) ([]string, error) {
 	if args["provider"] != "scaleway" {
 		return nil, fmt.Errorf("discover-scaleway: invalid provider " + args["provider"])
 	}
@@ -10,6 +10,7 @@ import (
 )
 
 var _ discover.Provider = (*scaleway.Provider)(nil)
+var _ discover.ProviderWithContext = (*scaleway.Provider)(nil)
 
 func TestAddrs(t *testing.T) {
 	args := discover.Config{
@@ -2,10 +2,12 @@
 package softlayer
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 
+	"github.com/hashicorp/go-discover/provider"
 	"github.com/softlayer/softlayer-go/filter"
 	"github.com/softlayer/softlayer-go/services"
 	"github.com/softlayer/softlayer-go/session"
@@ -25,6 +27,20 @@ func (p *Provider) Help() string {
 }
 
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and returns when ctx is
+// done. The SoftLayer session does not take a context, so an abandoned
+// lookup finishes its API call in the background.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
+	return provider.LookupContext(ctx, func() ([]string, error) {
+		return p.addrs(args, l)
+	})
+}
+
+// addrs looks up the addresses without a context.
+func (p *Provider) addrs(args map[string]string, l *log.Logger) ([]string, error) {
 	if args["provider"] != "softlayer" {
 		return nil, fmt.Errorf("discover-softlayer: invalid provider " + args["provider"])
 	}
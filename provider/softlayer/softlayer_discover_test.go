@@ -9,6 +9,9 @@ import (
 	"github.com/hashicorp/go-discover/provider/softlayer"
 )
 
+var _ discover.Provider = (*softlayer.Provider)(nil)
+var _ discover.ProviderWithContext = (*softlayer.Provider)(nil)
+
 func TestAddrs(t *testing.T) {
 	args := discover.Config{
 		"provider":   "softlayer",
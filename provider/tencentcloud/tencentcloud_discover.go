@@ -2,6 +2,7 @@
 package tencentcloud
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -36,6 +37,12 @@ func (p *Provider) Help() string {
 }
 
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and aborts the
+// DescribeInstances request when ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
 	if args["provider"] != "tencentcloud" {
 		return nil, fmt.Errorf("discover-tencentcloud: invalid provider " + args["provider"])
 	}
@@ -98,7 +105,7 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 		},
 	}
 
-	response, err := cvmClient.DescribeInstances(request)
+	response, err := cvmClient.DescribeInstancesWithContext(ctx, request)
 	if err != nil {
 		l.Printf("[DEBUG] discover-tencentcloud: DescribeInstances failed, %s", err)
 		return nil, fmt.Errorf("discover-tencentcloud: DescribeInstances failed, %s", err)
@@ -12,6 +12,7 @@ import (
 
 var _ discover.Provider = (*tencentcloud.Provider)(nil)
 var _ discover.ProviderWithUserAgent = (*tencentcloud.Provider)(nil)
+var _ discover.ProviderWithContext = (*tencentcloud.Provider)(nil)
 
 func TestAddrs(t *testing.T) {
 	args := discover.Config{
@@ -0,0 +1,44 @@
+package provider
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// TLSClient returns an HTTP client which verifies the server with the CA
+// certificate and authenticates with the client certificate if they are
+// set. The arguments are paths of PEM files. Without any of them a default
+// client is returned.
+func TLSClient(caCert, clientCert, clientKey string) (*http.Client, error) {
+	if caCert == "" && clientCert == "" && clientKey == "" {
+		return &http.Client{}, nil
+	}
+
+	config := &tls.Config{}
+	if caCert != "" {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("error reading ca_cert: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca_cert %s", caCert)
+		}
+		config.RootCAs = pool
+	}
+	if clientCert != "" || clientKey != "" {
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: config,
+	}}, nil
+}
@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discover-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := ioutil.WriteFile(invalid, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := TLSClient("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Transport != nil {
+		t.Fatalf("got transport %v want default", c.Transport)
+	}
+
+	tests := []struct {
+		name                          string
+		caCert, clientCert, clientKey string
+	}{
+		{"missing ca_cert", filepath.Join(dir, "missing.pem"), "", ""},
+		{"invalid ca_cert", invalid, "", ""},
+		{"client_cert without client_key", "", invalid, ""},
+		{"invalid client_cert", "", invalid, invalid},
+	}
+	for _, tt := range tests {
+		if _, err := TLSClient(tt.caCert, tt.clientCert, tt.clientKey); err == nil {
+			t.Fatalf("%s: expected error", tt.name)
+		}
+	}
+}
@@ -27,6 +27,12 @@ func (p *Provider) Help() string {
 }
 
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and aborts the API calls
+// when ctx is done.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
 	if args["provider"] != "triton" {
 		return nil, fmt.Errorf("discover-triton: invalid provider " + args["provider"])
 	}
@@ -70,7 +76,7 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 	listInput := &compute.ListInstancesInput{
 		Tags: t,
 	}
-	instances, err := c.Instances().List(context.Background(), listInput)
+	instances, err := c.Instances().List(ctx, listInput)
 	if err != nil {
 		return nil, fmt.Errorf("error getting instance list: %v", err)
 	}
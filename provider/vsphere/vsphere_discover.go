@@ -158,6 +158,12 @@ func (p *Provider) Help() string {
 
 // Addrs implements the Provider interface for the vsphere package.
 func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	return p.AddrsContext(context.Background(), args, l)
+}
+
+// AddrsContext looks up the addresses like Addrs and aborts the API calls
+// when ctx is done or the timeout expires, whichever happens first.
+func (p *Provider) AddrsContext(ctx context.Context, args map[string]string, l *log.Logger) ([]string, error) {
 	if args["provider"] != "vsphere" {
 		return nil, discoverErr("invalid provider %s", args["provider"])
 	}
@@ -179,7 +185,7 @@ func (p *Provider) Addrs(args map[string]string, l *log.Logger) ([]string, error
 		timeout = time.Minute * 10
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	client, err := newVSphereClient(ctx, host, user, password, insecure)
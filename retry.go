@@ -0,0 +1,114 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultRetryWaitMin is the default wait time before the first retry.
+	defaultRetryWaitMin = 500 * time.Millisecond
+
+	// defaultRetryWaitMax is the default maximum wait time between two
+	// retries.
+	defaultRetryWaitMax = 8 * time.Second
+)
+
+// retryPolicy configures how often and when a failed lookup is retried.
+type retryPolicy struct {
+	max     int
+	waitMin time.Duration
+	waitMax time.Duration
+}
+
+// retryPolicy returns the retry policy for args from the retry options or
+// the Retry fields of Discover.
+func (d *Discover) retryPolicy(args Config) (retryPolicy, error) {
+	p := retryPolicy{max: d.RetryMax, waitMin: d.RetryWaitMin, waitMax: d.RetryWaitMax}
+
+	if v := args["retry_max"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("discover: invalid retry_max %q", v)
+		}
+		p.max = n
+	}
+	for _, opt := range []struct {
+		key string
+		d   *time.Duration
+	}{
+		{"retry_wait_min", &p.waitMin},
+		{"retry_wait_max", &p.waitMax},
+	} {
+		if v := args[opt.key]; v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return p, fmt.Errorf("discover: invalid %s %q", opt.key, v)
+			}
+			*opt.d = d
+		}
+	}
+
+	if p.waitMin == 0 {
+		p.waitMin = defaultRetryWaitMin
+	}
+	if p.waitMax == 0 {
+		p.waitMax = defaultRetryWaitMax
+	}
+	if p.waitMin > p.waitMax {
+		return p, fmt.Errorf("discover: retry_wait_min %s is greater than retry_wait_max %s", p.waitMin, p.waitMax)
+	}
+	return p, nil
+}
+
+// wait returns the wait time before the given retry, starting with 0. It
+// doubles from waitMin up to waitMax and is reduced by a random jitter of up
+// to half of it so that clients do not retry in lockstep.
+func (p retryPolicy) wait(retry int) time.Duration {
+	wait := p.waitMin
+	for i := 0; i < retry && wait < p.waitMax; i++ {
+		wait *= 2
+	}
+	if wait > p.waitMax {
+		wait = p.waitMax
+	}
+	return wait - time.Duration(rand.Int63n(int64(wait)/2+1))
+}
+
+// retryNodes looks up the nodes with the provider like providerNodes and
+// retries failed lookups according to the retry policy for args.
+func (d *Discover) retryNodes(ctx context.Context, p Provider, args Config, l *log.Logger) ([]Node, error) {
+	policy, err := d.retryPolicy(args)
+	if err != nil {
+		return nil, err
+	}
+
+	for retry := 0; ; retry++ {
+		nodes, err := providerNodes(ctx, p, args, l)
+		if err == nil || retry >= policy.max || !retryable(p, err) || ctx.Err() != nil {
+			return nodes, err
+		}
+
+		wait := policy.wait(retry)
+		l.Printf("[INFO] discover: Lookup with provider %q failed, retrying in %s: %s", args["provider"], wait, err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retryable returns whether the provider considers the error transient.
+// Errors of providers which do not implement ProviderWithRetryable are
+// always retried.
+func retryable(p Provider, err error) bool {
+	if typ, ok := p.(ProviderWithRetryable); ok {
+		return typ.Retryable(err)
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fatalProvider treats all errors but err as fatal.
+type fatalProvider struct {
+	*flakyProvider
+	err error
+}
+
+func (p fatalProvider) Retryable(err error) bool { return err == p.err }
+
+func TestRetry(t *testing.T) {
+	rateLimited := errors.New("rate limited")
+	p := &flakyProvider{err: rateLimited}
+	d, err := New(
+		WithProviders(map[string]Provider{"flaky": p, "fatal": fatalProvider{p, rateLimited}}),
+		WithRetry(2, time.Millisecond, 2*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fails after the last retry
+	if _, err := d.Addrs("provider=flaky", nil); err != rateLimited {
+		t.Fatalf("got %v want %v", err, rateLimited)
+	}
+	if got := p.callCount(); got != 3 {
+		t.Fatalf("got %d calls want 3", got)
+	}
+
+	// the retry_max option overrides the default
+	if _, err := d.Addrs("provider=flaky retry_max=0", nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if got := p.callCount(); got != 4 {
+		t.Fatalf("got %d calls want 4", got)
+	}
+
+	// fatal errors are not retried
+	p.set(nil, errors.New("access denied"))
+	if _, err := d.Addrs("provider=fatal", nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if got := p.callCount(); got != 5 {
+		t.Fatalf("got %d calls want 5", got)
+	}
+
+	// succeeds once the error is gone
+	p.set([]string{"10.0.0.1"}, nil)
+	addrs, err := d.Addrs("provider=fatal retry_max=5", nil)
+	if err != nil || len(addrs) != 1 {
+		t.Fatalf("got %v, %v want [10.0.0.1]", addrs, err)
+	}
+}
+
+func TestRetryContext(t *testing.T) {
+	p := &flakyProvider{err: errors.New("rate limited")}
+	d := Discover{Providers: map[string]Provider{"flaky": p}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := d.AddrsContext(ctx, "provider=flaky retry_max=10 retry_wait_min=1s", nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("got %s want the lookup to stop with the context", elapsed)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	d := Discover{}
+	for _, cfg := range []string{
+		"retry_max=-1",
+		"retry_max=many",
+		"retry_wait_min=0s",
+		"retry_wait_max=soon",
+		"retry_wait_min=10s retry_wait_max=1s",
+	} {
+		args, err := Parse(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.retryPolicy(args); err == nil {
+			t.Fatalf("expected error for %q", cfg)
+		}
+	}
+	if _, err := New(WithRetry(-1, 0, 0)); err == nil {
+		t.Fatal("expected error for negative retries")
+	}
+
+	policy, err := d.retryPolicy(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for retry, max := range []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second} {
+		if wait := policy.wait(retry); wait < max/2 || wait > max {
+			t.Fatalf("retry %d: got wait %s want between %s and %s", retry, wait, max/2, max)
+		}
+	}
+}
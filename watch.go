@@ -0,0 +1,131 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// EventType is the type of a change of the discovered nodes.
+type EventType int
+
+const (
+	// EventAdd is sent for a node which was not discovered before.
+	EventAdd EventType = iota
+
+	// EventRemove is sent for a node which is no longer discovered.
+	EventRemove
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventAdd:
+		return "add"
+	case EventRemove:
+		return "remove"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event is a change of the discovered nodes.
+type Event struct {
+	// Type is the type of the change.
+	Type EventType
+
+	// Node is the added or removed node. For removed nodes it contains the
+	// metadata from the last lookup which returned the node.
+	Node Node
+}
+
+// Watch discovers the nodes for the config string like Nodes every interval
+// and sends an event for every node whose address was added or removed since
+// the previous lookup. The first lookup reports all nodes as added. Failed
+// lookups are logged and skipped so that a temporary error does not remove
+// all nodes. The channel is closed when ctx is done.
+func (d *Discover) Watch(ctx context.Context, cfg string, interval time.Duration, l *log.Logger) (<-chan Event, error) {
+	d.once.Do(d.initProviders)
+
+	if interval <= 0 {
+		return nil, fmt.Errorf("discover: invalid watch interval %s", interval)
+	}
+	cfgs, err := parseUnion(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("discover: %s", err)
+	}
+	for _, args := range cfgs {
+		if _, err := d.provider(args); err != nil {
+			return nil, err
+		}
+	}
+
+	l = d.stdLogger(l)
+
+	ch := make(chan Event)
+	go d.watch(ctx, cfg, interval, l, ch)
+	return ch, nil
+}
+
+// watch performs the lookups for Watch and closes ch when ctx is done.
+func (d *Discover) watch(ctx context.Context, cfg string, interval time.Duration, l *log.Logger, ch chan<- Event) {
+	defer close(ch)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var prev []Node
+	for {
+		nodes, err := d.NodesContext(ctx, cfg, l)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			l.Printf("[WARN] discover: Lookup failed, keeping the previous nodes: %s", err)
+		default:
+			for _, e := range diffNodes(prev, nodes) {
+				select {
+				case ch <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+			prev = nodes
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// diffNodes returns the events for the nodes whose address is only in prev
+// or only in cur. Removals are returned before additions.
+func diffNodes(prev, cur []Node) []Event {
+	inPrev := nodeAddrs(prev)
+	inCur := nodeAddrs(cur)
+
+	var events []Event
+	for _, n := range uniqueNodes(prev) {
+		if !inCur[n.Addr] {
+			events = append(events, Event{Type: EventRemove, Node: n})
+		}
+	}
+	for _, n := range uniqueNodes(cur) {
+		if !inPrev[n.Addr] {
+			events = append(events, Event{Type: EventAdd, Node: n})
+		}
+	}
+	return events
+}
+
+// nodeAddrs returns the set of addresses of the nodes.
+func nodeAddrs(nodes []Node) map[string]bool {
+	addrs := map[string]bool{}
+	for _, n := range nodes {
+		addrs[n.Addr] = true
+	}
+	return addrs
+}
@@ -0,0 +1,89 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sequenceProvider returns the next lookup result on every call and keeps
+// returning the last one.
+type sequenceProvider struct {
+	mu    sync.Mutex
+	addrs [][]string
+	errs  []error
+}
+
+func (p *sequenceProvider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	addrs, err := p.addrs[0], p.errs[0]
+	if len(p.addrs) > 1 {
+		p.addrs, p.errs = p.addrs[1:], p.errs[1:]
+	}
+	return addrs, err
+}
+
+func (p *sequenceProvider) Help() string { return "" }
+
+func TestWatch(t *testing.T) {
+	p := &sequenceProvider{
+		addrs: [][]string{
+			{"10.0.0.1", "10.0.0.2"},
+			nil,
+			{"10.0.0.2", "10.0.0.3"},
+		},
+		errs: []error{nil, errors.New("unavailable"), nil},
+	}
+	d := Discover{Providers: map[string]Provider{"seq": p}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := d.Watch(ctx, "provider=seq", time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for e := range ch {
+		got = append(got, e.Type.String()+" "+e.Node.Addr)
+		if len(got) == 4 {
+			cancel()
+		}
+	}
+
+	want := []string{"add 10.0.0.1", "add 10.0.0.2", "remove 10.0.0.1", "add 10.0.0.3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestWatchInvalid(t *testing.T) {
+	d := Discover{Providers: map[string]Provider{"ok": &testProvider{}}}
+	if _, err := d.Watch(context.Background(), "provider=ok", 0, nil); err == nil {
+		t.Fatal("expected error for zero interval")
+	}
+	if _, err := d.Watch(context.Background(), "provider=ok +", time.Second, nil); err == nil {
+		t.Fatal("expected error for invalid config")
+	}
+	if _, err := d.Watch(context.Background(), "provider=nope", time.Second, nil); err == nil {
+		t.Fatal("expected error for unknown provider")
+	}
+}
+
+func TestDiffNodes(t *testing.T) {
+	prev := []Node{{Addr: "a"}, {Addr: "b"}, {Addr: "b"}}
+	cur := []Node{{Addr: "b", Name: "renamed"}, {Addr: "c"}, {Addr: "c"}}
+
+	want := []Event{
+		{Type: EventRemove, Node: Node{Addr: "a"}},
+		{Type: EventAdd, Node: Node{Addr: "c"}},
+	}
+	if got := diffNodes(prev, cur); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package discover
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// WriteAddrs discovers the ip addresses for the config string like Addrs and
+// writes them to w in the given format:
+//
+//	lines: one address per line
+//	json:  a JSON array of strings, e.g. ["10.0.0.1","10.0.0.2"]
+//	csv:   a single comma-separated record, e.g. 10.0.0.1,10.0.0.2
+//	retry_join[:port]: a Consul/Nomad retry_join setting, e.g.
+//	       retry_join = ["10.0.0.1:8301", "[2a01:4f8::1]:8301"]
+//
+// An empty format defaults to "lines". Except for retry_join with a port the
+// addresses are written as returned by Addrs, i.e. IPv6 addresses are only
+// enclosed in brackets if they have a port, e.g. from the port option. See
+// FormatRetryJoin for the retry_join format.
+func (d *Discover) WriteAddrs(cfg string, l *log.Logger, w io.Writer, format string) error {
+	if err := checkFormat(format); err != nil {
+		return err
+	}
+
+	addrs, err := d.Addrs(cfg, l)
+	if err != nil {
+		return err
+	}
+	return writeAddrs(w, addrs, format)
+}
+
+// checkFormat returns an error if format is not supported by WriteAddrs.
+func checkFormat(format string) error {
+	if _, ok := retryJoinPort(format); ok {
+		return nil
+	}
+	switch format {
+	case "", "lines", "json", "csv":
+		return nil
+	default:
+		return fmt.Errorf("discover: unknown format %q", format)
+	}
+}
+
+// retryJoinPort returns the port of a "retry_join[:port]" format and whether
+// format is a retry_join format at all.
+func retryJoinPort(format string) (string, bool) {
+	if format == "retry_join" {
+		return "", true
+	}
+	if !strings.HasPrefix(format, "retry_join:") {
+		return "", false
+	}
+	port := strings.TrimPrefix(format, "retry_join:")
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", false
+	}
+	return port, true
+}
+
+// FormatRetryJoin formats addresses as entries for the retry_join setting of
+// Consul and Nomad. If port is not empty it is appended to every address
+// without a port and IPv6 addresses are enclosed in brackets, e.g.
+// "[2a01:4f8::1]:8301". Addresses with a port are kept as is. Every entry is
+// enclosed in double quotes.
+func FormatRetryJoin(addrs []string, port string) []string {
+	var entries []string
+	for _, addr := range addrs {
+		if port != "" {
+			addr = withPort(addr, port)
+		}
+		entries = append(entries, strconv.Quote(addr))
+	}
+	return entries
+}
+
+// writeAddrs writes addrs to w in the given format.
+func writeAddrs(w io.Writer, addrs []string, format string) error {
+	if port, ok := retryJoinPort(format); ok {
+		entries := FormatRetryJoin(addrs, port)
+		_, err := fmt.Fprintf(w, "retry_join = [%s]\n", strings.Join(entries, ", "))
+		return err
+	}
+
+	switch format {
+	case "", "lines":
+		for _, addr := range addrs {
+			if _, err := fmt.Fprintln(w, addr); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "json":
+		if addrs == nil {
+			addrs = []string{}
+		}
+		return json.NewEncoder(w).Encode(addrs)
+
+	case "csv":
+		if len(addrs) == 0 {
+			return nil
+		}
+		cw := csv.NewWriter(w)
+		if err := cw.Write(addrs); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		return fmt.Errorf("discover: unknown format %q", format)
+	}
+}
@@ -0,0 +1,74 @@
+package discover
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestWriteAddrs(t *testing.T) {
+	d := Discover{
+		Providers: map[string]Provider{
+			"test":  &testProvider{addrs: []string{"10.0.0.1", "2a01:4f8::1"}},
+			"empty": &testProvider{},
+		},
+	}
+
+	tests := []struct {
+		cfg    string
+		format string
+		out    string
+		err    error
+	}{
+		{`provider=test`, "", "10.0.0.1\n2a01:4f8::1\n", nil},
+		{`provider=test`, "lines", "10.0.0.1\n2a01:4f8::1\n", nil},
+		{`provider=test`, "json", `["10.0.0.1","2a01:4f8::1"]` + "\n", nil},
+		{`provider=test`, "csv", "10.0.0.1,2a01:4f8::1\n", nil},
+		{`provider=empty`, "lines", "", nil},
+		{`provider=empty`, "json", "[]\n", nil},
+		{`provider=empty`, "csv", "", nil},
+		{`provider=test`, "retry_join", `retry_join = ["10.0.0.1", "2a01:4f8::1"]` + "\n", nil},
+		{`provider=test`, "retry_join:8301", `retry_join = ["10.0.0.1:8301", "[2a01:4f8::1]:8301"]` + "\n", nil},
+		{`provider=empty`, "retry_join:8301", "retry_join = []\n", nil},
+		{`provider=test`, "xml", "", errors.New(`discover: unknown format "xml"`)},
+		{`provider=test`, "retry_join:http", "", errors.New(`discover: unknown format "retry_join:http"`)},
+		{`provider=test`, "retry_join:70000", "", errors.New(`discover: unknown format "retry_join:70000"`)},
+	}
+
+	l := log.New(os.Stderr, "", log.LstdFlags)
+	for _, tt := range tests {
+		t.Run(tt.cfg+" "+tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := d.WriteAddrs(tt.cfg, l, &buf, tt.format)
+			if got, want := err, tt.err; (got == nil) != (want == nil) || (got != nil && got.Error() != want.Error()) {
+				t.Fatalf("got error %v want %v", got, want)
+			}
+			if got, want := buf.String(), tt.out; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFormatRetryJoin(t *testing.T) {
+	tests := []struct {
+		addrs   []string
+		port    string
+		entries []string
+	}{
+		{nil, "8301", nil},
+		{[]string{"10.0.0.1"}, "", []string{`"10.0.0.1"`}},
+		{[]string{"10.0.0.1", "::1"}, "8301", []string{`"10.0.0.1:8301"`, `"[::1]:8301"`}},
+		{[]string{"consul.service"}, "8301", []string{`"consul.service:8301"`}},
+		{[]string{"10.0.0.1:8302", "[::1]:8302", "[::2]"}, "8301", []string{`"10.0.0.1:8302"`, `"[::1]:8302"`, `"[::2]:8301"`}},
+	}
+
+	for _, tt := range tests {
+		if got, want := FormatRetryJoin(tt.addrs, tt.port), tt.entries; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v want %v", got, want)
+		}
+	}
+}